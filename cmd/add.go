@@ -0,0 +1,259 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	internalerror "github.com/Consoleaf/kopl/internal_error"
+	"github.com/Consoleaf/kopl/koplugin"
+	"github.com/spf13/cobra"
+)
+
+// KOReader runs on LuaJIT
+const luaVersion = "5.1"
+
+var (
+	vendorDir    string
+	rockPrebuilt string
+)
+
+func init() {
+	rootCmd.AddCommand(addCmd)
+
+	addCmd.Flags().StringVar(
+		&vendorDir,
+		"vendor-dir",
+		"",
+		"Directory inside the plugin to vendor rocks into, remembered in kopl.toml (default \"vendor\")",
+	)
+	addCmd.Flags().StringVar(
+		&rockPrebuilt,
+		"prebuilt",
+		"",
+		"Directory with C modules cross-compiled for the device, laid out like lib/lua/5.1",
+	)
+}
+
+var addCmd = &cobra.Command{
+	Use:   "add [rock] [version]",
+	Short: "Vendor a LuaRocks package into the plugin",
+	Long: `Vendor a LuaRocks package into the plugin and set up main.lua to require
+modules from the vendor directory.
+
+Without a rock, only sets up main.lua, e.g. for vendored library dependencies.`,
+	Args: cobra.RangeArgs(0, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		projPath, err := os.Getwd()
+		if err != nil {
+			internalerror.ErrorExit(err)
+		}
+
+		err = addRock(projPath, args)
+		if err != nil {
+			internalerror.ErrorExit(err)
+		}
+	},
+}
+
+func addRock(projPath string, args []string) error {
+	_, err := os.Stat(filepath.Join(projPath, koplugin.MetaFile))
+	if err != nil {
+		return fmt.Errorf("%s doesn't look like a plugin: %w", projPath, err)
+	}
+
+	config, err := koplugin.ReadConfig(projPath)
+	if err != nil {
+		return err
+	}
+	if vendorDir != "" {
+		config.VendorDir = vendorDir
+	}
+	if len(args) == 0 {
+		if vendorDir != "" {
+			err = config.Write(projPath)
+			if err != nil {
+				return err
+			}
+		}
+		return ensureVendorLoader(projPath, config.Vendor())
+	}
+
+	luarocks, err := getLuarocks()
+	if err != nil {
+		return err
+	}
+
+	// LuaRocks would build C modules for the host before they could be refused
+	if rockPrebuilt == "" {
+		version := ""
+		if len(args) > 1 {
+			version = args[1]
+		}
+		cModules, err := rockCModules(luarocks, args[0], version)
+		if err != nil {
+			return err
+		}
+		if len(cModules) != 0 {
+			return cModulesError(args[0], cModules)
+		}
+	}
+
+	tree, err := os.MkdirTemp("", "kopl-rocks-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tree)
+
+	install := exec.Command(
+		luarocks,
+		append([]string{"install", "--tree", tree, "--lua-version", luaVersion}, args...)...,
+	)
+	install.Stdout = os.Stdout
+	install.Stderr = os.Stderr
+	err = install.Run()
+	if err != nil {
+		return fmt.Errorf("while running `luarocks install`: %w", err)
+	}
+
+	// In case a rockspec compiles in a way it doesn't tell
+	cModules, err := listFiles(filepath.Join(tree, "lib", "lua", luaVersion))
+	if err != nil {
+		return err
+	}
+	if len(cModules) != 0 && rockPrebuilt == "" {
+		return cModulesError(args[0], cModules)
+	}
+
+	destination := filepath.Join(projPath, config.Vendor())
+	err = copyTree(filepath.Join(tree, "share", "lua", luaVersion), destination)
+	if err != nil {
+		return err
+	}
+	if rockPrebuilt != "" {
+		err = copyTree(rockPrebuilt, destination)
+		if err != nil {
+			return err
+		}
+	}
+
+	rocks, err := listRocks(luarocks, tree)
+	if err != nil {
+		return err
+	}
+
+	if config.Rocks == nil {
+		config.Rocks = map[string]string{}
+	}
+	for name, version := range rocks {
+		config.Rocks[name] = version
+		logger.Info(fmt.Sprintf("Vendored %s %s into '%s'", name, version, destination))
+	}
+	err = config.Write(projPath)
+	if err != nil {
+		return err
+	}
+	return ensureVendorLoader(projPath, config.Vendor())
+}
+
+func cModulesError(rock string, cModules []string) error {
+	return fmt.Errorf(
+		"%s contains C modules, which need to be cross-compiled for the device:\n  %s\n"+
+			"Build them for the device and pass the build with --prebuilt",
+		rock,
+		strings.Join(cModules, "\n  "),
+	)
+}
+
+// listRocks returns the rocks installed in tree and their versions.
+func listRocks(luarocks string, tree string) (map[string]string, error) {
+	out, err := exec.Command(luarocks, "list", "--tree", tree, "--porcelain").Output()
+	if err != nil {
+		return nil, fmt.Errorf("while running `luarocks list`: %w", err)
+	}
+
+	rocks := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		// name	version	status	tree
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		rocks[fields[0]] = fields[1]
+	}
+	return rocks, scanner.Err()
+}
+
+// listFiles returns the files under dir, relative to it.
+// A missing dir has no files.
+func listFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			relPath, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, relPath)
+		}
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return files, err
+}
+
+// copyTree copies the contents of src into dst, overwriting existing files.
+func copyTree(src string, dst string) error {
+	files, err := listFiles(src)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		target := filepath.Join(dst, file)
+		err = os.MkdirAll(filepath.Dir(target), 0o755)
+		if err != nil {
+			return err
+		}
+		err = copyFile(filepath.Join(src, file), target)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/pkg/sftp"
+)
+
+// remoteStateDir is where kopl keeps staging directories and backups, next
+// to the plugins. KOReader only loads plugins from top-level *.koplugin
+// directories, so nothing in it is loaded.
+const remoteStateDir = ".kopl"
+
+// Before remoteStateDir, staging directories and backups were kept as
+// .kopl-staging-<name> and .kopl-backup-<name> next to the plugin, where
+// KOReader loaded them as duplicates of it.
+var legacyPrefixes = []string{".kopl-staging-", ".kopl-backup-"}
+
+// Overlay is a local directory uploaded into a subdirectory of a plugin,
+// like a vendored library.
+type Overlay struct {
+	LocalPath string
+	// Dir is relative to the plugin
+	Dir string
+}
+
+// Deployment is a plugin directory that was swapped in on the device.
+type Deployment struct {
+	LivePath string
+	// BackupPath is where the previous version was moved to.
+	// Empty if the plugin wasn't installed before.
+	BackupPath string
+}
+
+// UploadDirectoryAtomic uploads localPath into a staging directory next to
+// the live plugin and only then swaps it in with a rename, so an interrupted
+// upload never leaves a half-written plugin behind.
+// The previous version is kept as a backup. Overlays are uploaded into
+// the staging directory too.
+func UploadDirectoryAtomic(
+	localPath string,
+	remoteParentDir string,
+	client *sftp.Client,
+	overlays ...Overlay,
+) (*Deployment, error) {
+	name := path.Base(localPath)
+	livePath := path.Join(remoteParentDir, name)
+	stagingPath := path.Join(remoteParentDir, remoteStateDir, "staging", name)
+	backupPath := path.Join(remoteParentDir, remoteStateDir, "backup", name)
+
+	// Leftovers of a previously interrupted upload
+	err := removeRemoteIfExists(client, stagingPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, prefix := range legacyPrefixes {
+		err = removeRemoteIfExists(client, path.Join(remoteParentDir, prefix+name))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Info(fmt.Sprintf(
+		"Starting upload of local directory '%s' to staging directory '%s'...",
+		localPath,
+		stagingPath,
+	))
+	err = uploadTree(localPath, stagingPath, client)
+	for _, overlay := range overlays {
+		if err != nil {
+			break
+		}
+		err = uploadTree(overlay.LocalPath, path.Join(stagingPath, overlay.Dir), client)
+	}
+	if err != nil {
+		_ = removeRemoteIfExists(client, stagingPath)
+		return nil, err
+	}
+
+	deployment := &Deployment{LivePath: livePath}
+
+	_, err = client.Stat(livePath)
+	if err == nil {
+		err = removeRemoteIfExists(client, backupPath)
+		if err != nil {
+			return nil, err
+		}
+		err = client.MkdirAll(path.Dir(backupPath))
+		if err != nil {
+			return nil, err
+		}
+		err = client.Rename(livePath, backupPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up %s: %w", livePath, err)
+		}
+		deployment.BackupPath = backupPath
+		logger.Debug("Backed up previous version", "path", backupPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	err = client.Rename(stagingPath, livePath)
+	if err != nil {
+		if deployment.BackupPath != "" {
+			_ = client.Rename(backupPath, livePath)
+		}
+		return nil, fmt.Errorf("failed to swap in %s: %w", livePath, err)
+	}
+
+	logger.Info(fmt.Sprintf("Swapped in '%s'", livePath))
+	return deployment, nil
+}
+
+// Rollback restores the version that was live before the deployment.
+func (d *Deployment) Rollback(client *sftp.Client) error {
+	err := removeRemoteIfExists(client, d.LivePath)
+	if err != nil {
+		return err
+	}
+	if d.BackupPath == "" {
+		return nil
+	}
+	return client.Rename(d.BackupPath, d.LivePath)
+}
+
+// ManualRollback is a shell command restoring the previous version by hand.
+func (d *Deployment) ManualRollback() string {
+	if d.BackupPath == "" {
+		return fmt.Sprintf("rm -r '%s'", d.LivePath)
+	}
+	return fmt.Sprintf("rm -r '%s' && mv '%s' '%s'", d.LivePath, d.BackupPath, d.LivePath)
+}
+
+func removeRemoteIfExists(client *sftp.Client, remotePath string) error {
+	_, err := client.Stat(remotePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = client.RemoveAll(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to remove remote %s: %w", remotePath, err)
+	}
+	return nil
+}
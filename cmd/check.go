@@ -1,104 +1,310 @@
 package cmd
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
-	"path"
+	"path/filepath"
+	"slices"
 	"strings"
 
+	"github.com/Consoleaf/kopl/diagnostics"
 	internalerror "github.com/Consoleaf/kopl/internal_error"
+	"github.com/Consoleaf/kopl/koplugin"
+	"github.com/Consoleaf/kopl/lint"
 	"github.com/spf13/cobra"
 )
 
+var (
+	checkFormat     string
+	checkInitConfig bool
+	checkDisable    []string
+	checkKOReaders  []string
+	checkTypes      bool
+)
+
 func init() {
 	rootCmd.AddCommand(checkCmd)
+
+	checkCmd.Flags().StringVar(
+		&checkFormat,
+		"format",
+		"text",
+		"Output format: "+strings.Join(diagnostics.FormatNames, ", "),
+	)
+	checkCmd.Flags().BoolVar(
+		&checkInitConfig,
+		"init-config",
+		false,
+		"Write KOReader's globals and defaults into .luacheckrc, keeping your own settings, and exit",
+	)
+	checkCmd.Flags().StringSliceVar(
+		&checkDisable,
+		"disable",
+		nil,
+		"IDs or names of kopl's rules not to run, e.g. KOP006 (also [check] disable in kopl.toml)",
+	)
+	checkCmd.Flags().StringSliceVar(
+		&checkKOReaders,
+		"koreader-version",
+		nil,
+		"KOReader releases to check requires against besides the submodule, e.g. v2024.04 (also [check] koreader_versions in kopl.toml)",
+	)
+	checkCmd.Flags().BoolVar(
+		&checkTypes,
+		"types",
+		false,
+		"Also run lua-language-server's diagnostics, like type checks, with the project's .luarc.json",
+	)
 }
 
 var checkCmd = &cobra.Command{
-	Use:   "check",
+	Use:   "check [path...]",
 	Short: "Perform static checks on the project",
+	Long: `Perform static checks on the project.
+
+Checks the plugin in each path, or every *.koplugin directory under it,
+like in a repository with several plugins. Defaults to the current directory.
+
+KOReader's globals are known to luacheck through the defaults
+--init-config writes into .luacheckrc. Without one, they are used directly.
+
+With --types, lua-language-server checks types and more, configured by
+.luarc.json (created by kopl init, or kopl's defaults without one).
+
+Findings are printed as text, or with --format as JSON, SARIF (for code
+scanning), JUnit XML or GitHub Actions annotations.
+Exits with a non-zero code if there are any.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		projPath, err := os.Getwd()
+		write, ok := diagnostics.Formats[checkFormat]
+		if !ok {
+			internalerror.ErrorExitf(
+				"Unknown format %q. Use one of: %s\n",
+				checkFormat,
+				strings.Join(diagnostics.FormatNames, ", "),
+			)
+		}
+
+		plugins, err := findPluginsIn(args)
 		if err != nil {
-			panic(err)
+			internalerror.ErrorExit(err)
 		}
 
-		_ = assertFileExists(path.Join(projPath, "_meta.lua"))
-		_ = assertFileExists(path.Join(projPath, "main.lua"))
+		if checkInitConfig {
+			for _, dir := range plugins {
+				err = initLuacheckConfig(dir)
+				if err != nil {
+					internalerror.ErrorExit(err)
+				}
+			}
+			return
+		}
 
-		ensureLuacheck()
+		diags, err := checkImpl(plugins)
+		if err != nil {
+			internalerror.ErrorExit(err)
+		}
+		err = write(os.Stdout, diags)
+		if err != nil {
+			internalerror.ErrorExit(err)
+		}
+		if len(diags) > 0 {
+			os.Exit(1)
+		}
+	},
+}
 
-		fmt.Fprintln(os.Stderr, "Running luacheck...")
+// findPluginsIn returns the plugins in paths, the current directory if
+// there are none.
+func findPluginsIn(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	var plugins []string
+	for _, root := range paths {
+		found, err := koplugin.FindPlugins(root)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("no KOReader plugin found in %s", root)
+		}
+		plugins = append(plugins, found...)
+	}
+	return plugins, nil
+}
 
-		luacheck := exec.Command("luacheck", ".", "--exclude-files", "koreader")
-		luacheck.Stdout = os.Stdout
-		luacheck.Stderr = os.Stderr
-		err = luacheck.Run()
+// checkImpl checks the plugins and returns the findings of all of them,
+// with paths relative to the current directory.
+func checkImpl(plugins []string) ([]diagnostics.Diagnostic, error) {
+	err := ensureRock("luacheck", "luacheck")
+	if err != nil {
+		return nil, err
+	}
+
+	var all []diagnostics.Diagnostic
+	for _, dir := range plugins {
+		diags, err := checkPlugin(dir)
 		if err != nil {
-			internalerror.ErrorExitf("While running luacheck:\n%v\n", err)
+			return nil, fmt.Errorf("while checking %s: %w", dir, err)
 		}
-	},
+		for _, diag := range diags {
+			diag.File = filepath.ToSlash(filepath.Join(dir, diag.File))
+			all = append(all, diag)
+		}
+	}
+	diagnostics.Sort(all)
+	return all, nil
+}
+
+// checkPlugin runs luacheck and kopl's rules on the plugin in dir.
+func checkPlugin(dir string) ([]diagnostics.Diagnostic, error) {
+	fmt.Fprintf(os.Stderr, "Running luacheck on %s...\n", dir)
+	diags, err := runLuacheck(dir)
+	if err != nil {
+		return nil, fmt.Errorf("while running luacheck: %w", err)
+	}
+
+	lintDiags, err := runLint(dir)
+	if err != nil {
+		return nil, fmt.Errorf("while running kopl's rules: %w", err)
+	}
+	diags = append(diags, lintDiags...)
+
+	if checkTypes {
+		fmt.Fprintf(os.Stderr, "Running lua-language-server on %s...\n", dir)
+		typeDiags, err := runTypeCheck(dir)
+		if err != nil {
+			return nil, fmt.Errorf("while running lua-language-server: %w", err)
+		}
+		diags = append(diags, typeDiags...)
+	}
+	return diags, nil
 }
 
-func assertFileExists(path string) *os.File {
-	file, err := os.Open(path)
+// runLint runs kopl's own rules on the plugin in dir, except the ones
+// disabled with --disable or in kopl.toml.
+func runLint(dir string) ([]diagnostics.Diagnostic, error) {
+	config, err := koplugin.ReadConfig(dir)
 	if err != nil {
-		internalerror.ErrorExitf("File doesn't exist: %s\n%q\n", path, err)
+		return nil, err
+	}
+	disabled := append(config.Check.Disable, checkDisable...)
+	for _, rule := range disabled {
+		if _, ok := lint.Lookup(rule); !ok {
+			fmt.Fprintf(os.Stderr, "WARNING: there is no rule %q to disable\n", rule)
+		}
+	}
+
+	versions := append(config.Check.KOReaderVersions, checkKOReaders...)
+	slices.Sort(versions)
+	options := lint.Options{Disabled: disabled}
+	for _, version := range slices.Compact(versions) {
+		tree, err := koreaderTree(version)
+		if err != nil {
+			return nil, err
+		}
+		options.KOReaders = append(options.KOReaders, tree)
 	}
-	return file
+
+	return lint.Run(dir, options)
 }
 
-func ensureLuacheck() {
-	_, err := exec.LookPath("luacheck")
+// runLuacheck checks dir, leaving the koreader submodule out.
+func runLuacheck(dir string) ([]diagnostics.Diagnostic, error) {
+	configArgs, cleanup, err := luacheckConfigArgs(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	args := append([]string{".", "--exclude-files", "koreader"}, diagnostics.LuacheckArgs...)
+	args = append(args, configArgs...)
+	luacheck := exec.Command("luacheck", args...)
+	luacheck.Dir = dir
+	var stderr bytes.Buffer
+	luacheck.Stderr = &stderr
+
+	out, err := luacheck.Output()
+	// Exit codes up to 3 mean that luacheck found something, which is in the output.
+	// Higher ones are for its own failures, e.g. a broken config.
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() <= 3 {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w\n%s", err, stderr.String())
+	}
+	return diagnostics.ParseLuacheck(bytes.NewReader(out))
+}
+
+// ensureRock makes sure executable is in PATH, installing rock with
+// luarocks if it isn't.
+func ensureRock(executable string, rock string) error {
+	_, err := exec.LookPath(executable)
 	if err == nil {
-		return
+		return nil
 	}
 
-	importLuarocksPath()
-	_, err = exec.LookPath("luacheck")
+	err = importLuarocksPath()
+	if err != nil {
+		return err
+	}
+	_, err = exec.LookPath(executable)
 	if err == nil {
-		return
+		return nil
 	}
 
-	fmt.Fprintln(os.Stderr, "luacheck not found. Trying to install it using luarocks...")
+	fmt.Fprintf(os.Stderr, "%s not found. Trying to install it using luarocks...\n", executable)
 
-	luarocks := getLuarocks()
-	cmd := exec.Command(luarocks, "install", "--local", "luacheck")
-	cmd.Stdout = os.Stdout
+	luarocks, err := getLuarocks()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(luarocks, "install", "--local", rock)
+	// Keep stdout clean for --format
+	cmd.Stdout = os.Stderr
 	cmd.Stderr = os.Stderr
 	err = cmd.Run()
 	if err != nil {
-		internalerror.ErrorExit(err)
+		return fmt.Errorf("while installing %s: %w", rock, err)
 	}
+	return nil
 }
 
-func getLuarocks() string {
+func getLuarocks() (string, error) {
 	luarocks, err := exec.LookPath("luarocks")
 	if err != nil {
-		internalerror.ErrorExit("luarocks not found")
+		return "", fmt.Errorf("luarocks not found")
 	}
-	return luarocks
+	return luarocks, nil
 }
 
-func importLuarocksPath() {
-	luarocks := getLuarocks()
+func importLuarocksPath() error {
+	luarocks, err := getLuarocks()
+	if err != nil {
+		return err
+	}
 	luaRocksPathVar, err := exec.Command(luarocks, "path", "--lr-bin").Output()
 	if err != nil {
-		internalerror.ErrorExitf("While getting luarocks PATH: %v", err)
+		return fmt.Errorf("while getting luarocks PATH: %w", err)
 	}
-	if !strings.Contains(os.Getenv("PATH"), string(luaRocksPathVar)) {
-		fmt.Println("WARNING: Luarocks PATH isn't set up. This CLI will call Luacheck directly, but other tools might not.")
+	binPath := strings.TrimSpace(string(luaRocksPathVar))
+	if !strings.Contains(os.Getenv("PATH"), binPath) {
+		fmt.Fprintln(os.Stderr, "WARNING: Luarocks PATH isn't set up. This CLI will call Luacheck directly, but other tools might not.")
 
 		os.Setenv(
 			"PATH",
 			strings.Join(
 				[]string{
 					os.Getenv("PATH"),
-					string(luaRocksPathVar),
+					binPath,
 				},
 				string(os.PathListSeparator),
 			),
 		)
 	}
+	return nil
 }
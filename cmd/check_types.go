@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Consoleaf/kopl/diagnostics"
+	"github.com/Consoleaf/kopl/luatemplates"
+)
+
+// luaRcFile configures lua-language-server, `kopl init` writes it
+const luaRcFile = ".luarc.json"
+
+// runTypeCheck runs lua-language-server's diagnostics, including type
+// checks, on the plugin in dir.
+func runTypeCheck(dir string) ([]diagnostics.Diagnostic, error) {
+	luals, err := exec.LookPath("lua-language-server")
+	if err != nil {
+		return nil, fmt.Errorf(
+			"lua-language-server not found in PATH. " +
+				"Install it from https://luals.github.io/#other-install or your package manager",
+		)
+	}
+
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	logDir, err := os.MkdirTemp("", "kopl-luals-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(logDir)
+
+	configArgs, cleanup, err := luaRcConfigArgs(root)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	args := append([]string{
+		"--check=" + root,
+		"--checklevel=Warning",
+		"--logpath=" + logDir,
+	}, configArgs...)
+	cmd := exec.Command(luals, args...)
+	cmd.Dir = root
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	runErr := cmd.Run()
+	// Newer versions exit with 1 if they found something, which is in check.json.
+	// Crashes, like being killed, don't leave a complete one.
+	var exitErr *exec.ExitError
+	if runErr != nil && !(errors.As(runErr, &exitErr) && exitErr.Exited()) {
+		return nil, fmt.Errorf("%w\n%s", runErr, output.String())
+	}
+
+	data, err := os.ReadFile(filepath.Join(logDir, "check.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		if runErr != nil {
+			return nil, fmt.Errorf("%w without writing check.json\n%s", runErr, output.String())
+		}
+		// Not written if there's nothing to report
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	diags, err := diagnostics.ParseLuaLS(data, root)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse lua-language-server's check.json: %w", err)
+	}
+
+	// The koreader submodule is in the workspace, but only as a library
+	var own []diagnostics.Diagnostic
+	for _, diag := range diags {
+		if !strings.HasPrefix(diag.File, "koreader/") {
+			own = append(own, diag)
+		}
+	}
+	return own, nil
+}
+
+// luaRcConfigArgs points lua-language-server at kopl's default config if
+// the project doesn't have a .luarc.json.
+func luaRcConfigArgs(dir string) (args []string, cleanup func(), err error) {
+	cleanup = func() {}
+	if _, err := os.Stat(filepath.Join(dir, luaRcFile)); err == nil {
+		return nil, cleanup, nil
+	}
+
+	path, cleanup, err := writeTempConfig(luaRcFile, func(w io.Writer) error {
+		return luatemplates.LuaRcTemplate.Execute(w, luatemplates.TemplateArgsForInit{})
+	})
+	if err != nil {
+		return nil, cleanup, err
+	}
+	return []string{"--configpath=" + path}, cleanup, nil
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Consoleaf/kopl/koplugin"
+	"golang.org/x/mod/semver"
+)
+
+var forceDeploy bool
+
+// assertLooksLikePlugin checks that pluginDir has the files KOReader needs
+// to load it as a plugin.
+func assertLooksLikePlugin(pluginDir string) error {
+	for _, name := range []string{koplugin.MetaFile, "main.lua"} {
+		_, err := os.Stat(filepath.Join(pluginDir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("'%s' doesn't look like a KOReader plugin: %s is missing", pluginDir, name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCompatibility compares the minimum KOReader version and supported
+// devices declared in the plugin's `_meta.lua` with the device.
+// Plugins without one declare nothing to check.
+func checkCompatibility(pluginDir string) error {
+	meta, err := koplugin.ReadMeta(pluginDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	if meta.MinKOReaderVersion != "" {
+		required := koplugin.KOReaderVersion(meta.MinKOReaderVersion)
+		if required == "" {
+			return fmt.Errorf("invalid min_koreader_version %q", meta.MinKOReaderVersion)
+		}
+
+		revision, err := deviceKOReaderRevision()
+		if err != nil {
+			return fmt.Errorf("couldn't determine KOReader version on the device: %w", err)
+		}
+		logger.Debug("KOReader on the device", "revision", revision)
+
+		current := koplugin.KOReaderVersion(revision)
+		if current == "" {
+			logger.Warn(fmt.Sprintf("Unrecognized KOReader revision %q, skipping version check", revision))
+		} else if semver.Compare(current, required) < 0 {
+			errs = append(errs, fmt.Errorf(
+				"%s requires KOReader %s or newer, the device runs %s",
+				meta.Name,
+				meta.MinKOReaderVersion,
+				revision,
+			))
+		}
+	}
+
+	if len(meta.SupportedDevices) != 0 {
+		model, err := deviceModel()
+		if err != nil {
+			return fmt.Errorf("couldn't determine the device model: %w", err)
+		}
+		logger.Debug("Device", "model", model)
+
+		if !koplugin.SupportsDevice(meta.SupportedDevices, model) {
+			errs = append(errs, fmt.Errorf(
+				"%s supports only %s, the device is %s",
+				meta.Name,
+				strings.Join(meta.SupportedDevices, ", "),
+				model,
+			))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// deviceKOReaderRevision asks HTTP Inspector for the revision of the
+// running KOReader.
+func deviceKOReaderRevision() (string, error) {
+	revision, err := Inspector.Get("koreader/version")
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(strings.TrimSpace(string(revision)), `"`), nil
+}
+
+// checkCompatibilities checks every plugin about to be deployed.
+// With --force, mismatches are only warned about.
+func checkCompatibilities(pluginDirs []string) error {
+	var errs []error
+	for _, pluginDir := range pluginDirs {
+		errs = append(errs, checkCompatibility(pluginDir))
+	}
+	err := errors.Join(errs...)
+	if err != nil && forceDeploy {
+		logger.Warn(fmt.Sprintf("%v\nDeploying anyway because of --force", err))
+		return nil
+	}
+	return err
+}
+
+// deviceModel asks HTTP Inspector for the model of KOReader's Device object.
+func deviceModel() (string, error) {
+	model, err := Inspector.Get("device/model")
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(strings.TrimSpace(string(model)), `"`), nil
+}
@@ -0,0 +1,423 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Consoleaf/kopl/koplugin"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/pkg/sftp"
+	"golang.org/x/mod/semver"
+)
+
+// dependency is a plugin or Lua library required by the one being deployed.
+type dependency struct {
+	Repo         string
+	Requirements []requirement
+
+	Installed bool
+	// Version is the installed or chosen version. Empty if unknown
+	// or if the default branch was chosen.
+	Version string
+	// LocalPath is the clone to upload. Empty if the installed version fits.
+	LocalPath string
+
+	// Library is set for repositories that aren't plugins. They can't be
+	// loaded next to the plugins, so they're vendored into the ones
+	// requiring them instead.
+	Library bool
+	// VendorInto are the local paths of the plugins a library is vendored into
+	VendorInto []string
+	// requires are the dependencies of the chosen version
+	requires []*dependency
+	// resolved is unset until a version is chosen, and again when
+	// a new requirement doesn't allow the chosen one
+	resolved bool
+}
+
+type requirement struct {
+	By         string
+	Constraint koplugin.Constraint
+}
+
+// allows reports whether version satisfies every requirement.
+func (d *dependency) allows(version string) bool {
+	for _, req := range d.Requirements {
+		if !req.Constraint.Allows(version) {
+			return false
+		}
+	}
+	return true
+}
+
+// unsatisfiedError reports that no version satisfies the requirements.
+func (d *dependency) unsatisfiedError() error {
+	if len(d.Requirements) == 1 {
+		return fmt.Errorf("no version of %s satisfies %s", d.Repo, d.Requirements[0].Constraint)
+	}
+	return d.conflictError()
+}
+
+func (d *dependency) conflictError() error {
+	var lines []string
+	for _, req := range d.Requirements {
+		lines = append(lines, fmt.Sprintf("  %s requires %s", req.By, req.Constraint))
+	}
+	return fmt.Errorf(
+		"conflicting requirements for %s:\n%s",
+		d.Repo,
+		strings.Join(lines, "\n"),
+	)
+}
+
+// maxResolveRounds bounds how many times versions are chosen, in case
+// requirements of different versions keep replacing each other.
+const maxResolveRounds = 100
+
+type dependencyResolver struct {
+	client       *sftp.Client
+	tmp          string
+	dependencies map[string]*dependency
+	order        []*dependency
+	// root are the dependencies of the plugin being deployed
+	root []*dependency
+}
+
+// resolveDependencies finds the plugins and libraries pluginDir depends
+// on, recursively, and clones the ones that are missing on the device or
+// don't satisfy the version constraints. Versions are chosen to satisfy
+// the constraints of every plugin requiring them. The returned cleanup
+// removes the clones.
+func resolveDependencies(pluginDir string, client *sftp.Client) ([]*dependency, func(), error) {
+	tmp, err := os.MkdirTemp("", "kopl-deps-")
+	if err != nil {
+		return nil, func() {}, err
+	}
+	cleanup := func() { os.RemoveAll(tmp) }
+
+	resolver := &dependencyResolver{
+		client:       client,
+		tmp:          tmp,
+		dependencies: map[string]*dependency{},
+	}
+	requirements, err := pluginRequirements(pluginDir)
+	if err != nil {
+		return nil, cleanup, err
+	}
+	err = resolver.require(path.Base(pluginDir), nil, requirements)
+	if err != nil {
+		return nil, cleanup, err
+	}
+	err = resolver.settle()
+	if err != nil {
+		return nil, cleanup, err
+	}
+
+	var dependencies []*dependency
+	for _, dep := range resolver.order {
+		if len(dep.Requirements) > 0 {
+			dependencies = append(dependencies, dep)
+		}
+	}
+	vendorLibraries(pluginDir, resolver.root)
+	for _, dep := range dependencies {
+		if dep.LocalPath != "" && !dep.Library {
+			vendorLibraries(dep.LocalPath, dep.requires)
+		}
+	}
+	return dependencies, cleanup, nil
+}
+
+// pluginRequirements merges dependencies declared in `_meta.lua` and kopl.toml.
+func pluginRequirements(pluginDir string) (map[string]string, error) {
+	meta, err := koplugin.ReadMeta(pluginDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	config, err := koplugin.ReadConfig(pluginDir)
+	if err != nil {
+		return nil, err
+	}
+	return mergeRequirements(meta, config), nil
+}
+
+// mergeRequirements merges dependencies declared in `_meta.lua`, which may
+// be missing, and kopl.toml.
+func mergeRequirements(meta *koplugin.Meta, config *koplugin.Config) map[string]string {
+	requirements := map[string]string{}
+	if meta != nil {
+		for repo, constraint := range meta.Dependencies {
+			requirements[repo] = constraint
+		}
+	}
+	for repo, constraint := range config.Dependencies {
+		if existing := requirements[repo]; existing != "" && constraint != "" {
+			constraint = existing + ", " + constraint
+		} else if constraint == "" {
+			constraint = existing
+		}
+		requirements[repo] = constraint
+	}
+	return requirements
+}
+
+// require adds the requirements of by, which is parent or, if parent is
+// nil, the plugin being deployed. Dependencies whose chosen version they
+// don't allow are chosen again.
+func (r *dependencyResolver) require(by string, parent *dependency, requirements map[string]string) error {
+	repos := make([]string, 0, len(requirements))
+	for repo := range requirements {
+		repos = append(repos, repo)
+	}
+	slices.Sort(repos)
+
+	for _, repo := range repos {
+		constraint, err := koplugin.ParseConstraint(requirements[repo])
+		if err != nil {
+			return fmt.Errorf("%s: %w", by, err)
+		}
+
+		key := strings.ToLower(repoURL(repo))
+		dep, ok := r.dependencies[key]
+		if !ok {
+			dep = &dependency{Repo: repo}
+			r.dependencies[key] = dep
+			r.order = append(r.order, dep)
+		}
+		dep.Requirements = append(dep.Requirements, requirement{By: by, Constraint: constraint})
+		if parent != nil {
+			parent.requires = append(parent.requires, dep)
+		} else {
+			r.root = append(r.root, dep)
+		}
+
+		if dep.resolved && !constraint.Allows(dep.Version) {
+			logger.Debug("Choosing a dependency's version again", "repo", dep.Repo, "version", dep.Version, "by", by)
+			r.unresolve(dep)
+		}
+	}
+	return nil
+}
+
+// unresolve drops the chosen version of dep and the requirements it
+// brought, so that it's chosen again.
+func (r *dependencyResolver) unresolve(dep *dependency) {
+	for _, child := range dep.requires {
+		child.Requirements = slices.DeleteFunc(child.Requirements, func(req requirement) bool {
+			return req.By == dep.Repo
+		})
+		// Nothing else requires it anymore
+		if len(child.Requirements) == 0 && child.resolved {
+			r.unresolve(child)
+		}
+	}
+	if dep.LocalPath != "" {
+		os.RemoveAll(dep.LocalPath)
+	}
+	dep.requires = nil
+	dep.resolved, dep.Installed, dep.Library = false, false, false
+	dep.Version, dep.LocalPath = "", ""
+}
+
+// settle chooses versions until every required dependency has one.
+func (r *dependencyResolver) settle() error {
+	for round := 0; ; round++ {
+		i := slices.IndexFunc(r.order, func(dep *dependency) bool {
+			return !dep.resolved && len(dep.Requirements) > 0
+		})
+		if i < 0 {
+			return nil
+		}
+		if round == maxResolveRounds {
+			return fmt.Errorf("versions of %s keep changing while resolving dependencies", r.order[i].Repo)
+		}
+		err := r.resolve(r.order[i])
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// vendorLibraries marks the libraries in requires, and the ones they
+// require, to be vendored into the plugin at pluginPath.
+func vendorLibraries(pluginPath string, requires []*dependency) {
+	for _, dep := range requires {
+		if !dep.Library || slices.Contains(dep.VendorInto, pluginPath) {
+			continue
+		}
+		dep.VendorInto = append(dep.VendorInto, pluginPath)
+		vendorLibraries(pluginPath, dep.requires)
+	}
+}
+
+// resolve chooses the version of dep: the installed one if every
+// requirement allows it, or the highest allowed tag, which it clones.
+// Then it requires the dependencies of that version.
+func (r *dependencyResolver) resolve(dep *dependency) error {
+	installed, version, requirements, err := r.installedPlugin(dep.Repo)
+	if err != nil {
+		return err
+	}
+	if installed && dep.allows(version) {
+		logger.Info(fmt.Sprintf("Dependency %s %s is already installed", dep.Repo, version))
+		dep.Installed, dep.Version, dep.resolved = true, version, true
+		return r.require(dep.Repo, dep, requirements)
+	}
+
+	url := repoURL(dep.Repo)
+	tag, version, err := latestAllowedTag(url, dep.allows)
+	if err != nil {
+		return err
+	}
+	if tag == "" && !dep.allows("") {
+		return dep.unsatisfiedError()
+	}
+
+	dep.Version = version
+	dep.LocalPath = filepath.Join(r.tmp, strings.TrimSuffix(path.Base(dep.Repo), ".git"))
+
+	cloneOptions := &git.CloneOptions{URL: url, Depth: 1}
+	if tag != "" {
+		cloneOptions.ReferenceName = plumbing.NewTagReferenceName(tag)
+		cloneOptions.SingleBranch = true
+		logger.Info(fmt.Sprintf("Fetching dependency %s %s...", dep.Repo, tag))
+	} else {
+		logger.Info(fmt.Sprintf("Fetching dependency %s...", dep.Repo))
+	}
+	_, err = git.PlainClone(dep.LocalPath, false, cloneOptions)
+	if err != nil {
+		return fmt.Errorf("while fetching %s: %w", dep.Repo, err)
+	}
+	dep.resolved = true
+
+	if !koplugin.IsPlugin(dep.LocalPath) {
+		logger.Info(fmt.Sprintf("%s isn't a plugin, vendoring it as a library", dep.Repo))
+		dep.Library = true
+	} else {
+		// KOReader only loads plugins from *.koplugin directories
+		pluginPath := filepath.Join(r.tmp, pluginDirName(dep.Repo))
+		if pluginPath != dep.LocalPath {
+			err = os.Rename(dep.LocalPath, pluginPath)
+			if err != nil {
+				return err
+			}
+			dep.LocalPath = pluginPath
+		}
+	}
+
+	requirements, err = pluginRequirements(dep.LocalPath)
+	if err != nil {
+		return err
+	}
+	return r.require(dep.Repo, dep, requirements)
+}
+
+// libraryRoot is the directory of a library's clone its modules are
+// required relative to.
+func libraryRoot(clone string) string {
+	for _, dir := range []string{"lua", "src"} {
+		info, err := os.Stat(filepath.Join(clone, dir))
+		if err == nil && info.IsDir() {
+			return filepath.Join(clone, dir)
+		}
+	}
+	return clone
+}
+
+// vendoredLibraries returns the libraries to upload into the plugin at
+// pluginPath, into its vendor directory.
+func vendoredLibraries(pluginPath string, dependencies []*dependency) ([]Overlay, error) {
+	config, err := koplugin.ReadConfig(pluginPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var overlays []Overlay
+	for _, dep := range dependencies {
+		if !dep.Library || !slices.Contains(dep.VendorInto, pluginPath) {
+			continue
+		}
+		logger.Info(fmt.Sprintf("Vendoring %s into '%s'", dep.Repo, path.Join(path.Base(pluginPath), config.Vendor())))
+		overlays = append(overlays, Overlay{LocalPath: libraryRoot(dep.LocalPath), Dir: config.Vendor()})
+	}
+	if len(overlays) != 0 && !hasVendorLoader(pluginPath, config.Vendor()) {
+		logger.Warn(fmt.Sprintf(
+			"%s of '%s' doesn't let modules be required from '%s'. Run `kopl add` in the plugin to set it up",
+			pluginMainFile,
+			path.Base(pluginPath),
+			config.Vendor(),
+		))
+	}
+	return overlays, nil
+}
+
+// installedPlugin reads the version and the dependencies of a plugin
+// installed on the device.
+func (r *dependencyResolver) installedPlugin(repo string) (bool, string, map[string]string, error) {
+	pluginPath := path.Join(deployPath, pluginDirName(repo))
+	metaPath := path.Join(pluginPath, koplugin.MetaFile)
+	src, err := readRemoteFile(r.client, metaPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, "", nil, nil
+	}
+	if err != nil {
+		return false, "", nil, err
+	}
+	meta, err := koplugin.ParseMeta(src)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Couldn't parse %s: %v", metaPath, err))
+		return true, "", nil, nil
+	}
+
+	configPath := path.Join(pluginPath, koplugin.ConfigFile)
+	src, err = readRemoteFile(r.client, configPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, "", nil, err
+	}
+	config, err := koplugin.ParseConfig(src)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Couldn't parse %s: %v", configPath, err))
+		config = &koplugin.Config{}
+	}
+	return true, meta.Version, mergeRequirements(meta, config), nil
+}
+
+// latestAllowedTag returns the highest semver tag of the repository that
+// allows accepts. Both are empty if there is none.
+func latestAllowedTag(url string, allows func(version string) bool) (string, string, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{url},
+	})
+	refs, err := remote.List(&git.ListOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("while listing tags of %s: %w", url, err)
+	}
+
+	var bestTag, bestVersion string
+	for _, ref := range refs {
+		if !ref.Name().IsTag() {
+			continue
+		}
+		tag := ref.Name().Short()
+		version := koplugin.CanonicalVersion(tag)
+		if version == "" {
+			continue
+		}
+		if !allows(version) {
+			continue
+		}
+		if bestVersion == "" || semver.Compare(version, bestVersion) > 0 {
+			bestTag, bestVersion = tag, version
+		}
+	}
+	return bestTag, bestVersion, nil
+}
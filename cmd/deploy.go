@@ -1,26 +1,33 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/pkg/sftp"
 	"github.com/spf13/cobra"
 )
 
 var (
-	deployPath string
-	localPath  string
+	deployPath       string
+	localPath        string
+	restartTimeout   time.Duration
+	skipDependencies bool
 )
 
 func init() {
 	rootCmd.AddCommand(deployCmd)
 	AddInspectorArgs(deployCmd)
 	AddSSHFlags(deployCmd)
+	AddDeployFlags(deployCmd)
+}
 
-	deployCmd.Flags().StringVarP(
+func AddDeployPathFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(
 		&deployPath,
 		"deploy-path",
 		"d",
@@ -29,10 +36,40 @@ func init() {
 	)
 }
 
+func AddDeployFlags(cmd *cobra.Command) {
+	AddDeployPathFlag(cmd)
+	cmd.Flags().DurationVar(
+		&restartTimeout,
+		"restart-timeout",
+		time.Minute,
+		"How long to wait for KOReader to come back after restart before rolling back",
+	)
+	cmd.Flags().BoolVar(
+		&skipDependencies,
+		"no-deps",
+		false,
+		"Don't install the plugin's dependencies",
+	)
+	cmd.Flags().BoolVarP(
+		&forceDeploy,
+		"force",
+		"f",
+		false,
+		"Deploy even if a plugin doesn't declare support for the device or its KOReader version",
+	)
+}
+
 var deployCmd = &cobra.Command{
 	Use:   "deploy",
 	Short: "Deploy project to a device",
-	Args:  cobra.RangeArgs(0, 1),
+	Long: `Upload the plugin and its missing dependencies to the device and restart KOReader.
+
+Plugins are swapped in atomically, and the previous versions are kept in
+.kopl/backup in the plugins directory. If KOReader doesn't come back within
+--restart-timeout, they are restored automatically, but only with a
+standalone SSH server (--ssh-port): SSH started over HTTP Inspector goes
+down with KOReader, so kopl prints the commands to restore them by hand.`,
+	Args: cobra.RangeArgs(0, 1),
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 1 {
 			localPath = args[0]
@@ -58,26 +95,137 @@ var deployCmd = &cobra.Command{
 func deployCmdImpl(_ *cobra.Command, _ []string) error {
 	InitializeInspector()
 
-	defer restartKOReader()
-	revert, err := makeRevertSSHAllowNoPassword()
+	return deployAtomically(localPath)
+}
+
+// deployAtomically swaps localPath and its missing dependencies in on the
+// device, restarts KOReader and rolls back to the previous versions if
+// KOReader doesn't come back. Nothing is uploaded if one of them isn't
+// compatible with the device.
+func deployAtomically(localPath string) error {
+	deployments, err := uploadOverSSH(localPath)
 	if err != nil {
 		return err
 	}
+
+	if sshOverInspector {
+		logger.Warn(
+			"SSH runs over HTTP Inspector, which goes down with KOReader. " +
+				"If KOReader doesn't come back, the previous versions have to be restored by hand. " +
+				"Use --ssh-port with a standalone SSH server for automatic rollback",
+		)
+	}
+	restartKOReader()
+
+	err = waitForKOReader(restartTimeout)
+	if err == nil {
+		logger.Info("KOReader is back up")
+		return nil
+	}
+	logger.Error(err.Error())
+
+	return rollbackDeployments(deployments, err)
+}
+
+func uploadOverSSH(localPath string) ([]*Deployment, error) {
+	revert, err := makeRevertSSHAllowNoPassword()
+	if err != nil {
+		return nil, err
+	}
 	defer revert()
 
 	conn, err := connectSSH()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer conn.Close()
 
 	sftp, err := sftp.NewClient(conn)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer sftp.Close()
 
-	return UploadDirectory(localPath, deployPath, sftp)
+	localPaths := []string{localPath}
+	var dependencies []*dependency
+	if !skipDependencies {
+		var cleanup func()
+		dependencies, cleanup, err = resolveDependencies(localPath, sftp)
+		defer cleanup()
+		if err != nil {
+			return nil, err
+		}
+		for _, dep := range dependencies {
+			if dep.LocalPath != "" && !dep.Library {
+				localPaths = append(localPaths, dep.LocalPath)
+			}
+		}
+	}
+	err = checkCompatibilities(localPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	var deployments []*Deployment
+	for _, p := range localPaths {
+		libraries, err := vendoredLibraries(p, dependencies)
+		if err != nil {
+			rollbackErr := rollbackAll(deployments, sftp)
+			return nil, errors.Join(err, rollbackErr)
+		}
+		deployment, err := UploadDirectoryAtomic(p, deployPath, sftp, libraries...)
+		if err != nil {
+			rollbackErr := rollbackAll(deployments, sftp)
+			return nil, errors.Join(err, rollbackErr)
+		}
+		deployments = append(deployments, deployment)
+	}
+	return deployments, nil
+}
+
+func rollbackAll(deployments []*Deployment, client *sftp.Client) error {
+	var errs []error
+	for i := len(deployments) - 1; i >= 0; i-- {
+		errs = append(errs, deployments[i].Rollback(client))
+	}
+	return errors.Join(errs...)
+}
+
+func rollbackDeployments(deployments []*Deployment, cause error) error {
+	// SSH started over HTTP Inspector went down together with KOReader.
+	if sshOverInspector {
+		var commands []string
+		for _, deployment := range deployments {
+			commands = append(commands, deployment.ManualRollback())
+		}
+		return fmt.Errorf(
+			"%w\nCan't roll back automatically without a standalone SSH server (see --ssh-port). "+
+				"To restore the previous versions, run on the device:\n%s",
+			cause,
+			strings.Join(commands, "\n"),
+		)
+	}
+
+	logger.Warn("Rolling back to the previous version...")
+
+	conn, err := connectSSH()
+	if err != nil {
+		return fmt.Errorf("%w\nwhile rolling back: %w", cause, err)
+	}
+	defer conn.Close()
+
+	sftp, err := sftp.NewClient(conn)
+	if err != nil {
+		return fmt.Errorf("%w\nwhile rolling back: %w", cause, err)
+	}
+	defer sftp.Close()
+
+	err = rollbackAll(deployments, sftp)
+	if err != nil {
+		return fmt.Errorf("%w\nwhile rolling back: %w", cause, err)
+	}
+
+	return fmt.Errorf("%w\nRolled back to the previous version. Start KOReader on the device again", cause)
 }
 
 func validateLocalPath() error {
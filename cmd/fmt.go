@@ -0,0 +1,265 @@
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	internalerror "github.com/Consoleaf/kopl/internal_error"
+	"github.com/Consoleaf/kopl/koplugin"
+	"github.com/Consoleaf/kopl/luatemplates"
+	"github.com/Consoleaf/kopl/utils"
+	"github.com/spf13/cobra"
+)
+
+// StyLua release downloaded if it isn't installed
+const styluaVersion = "v2.1.0"
+
+// Names of the StyLua release archives by GOOS/GOARCH
+var styluaPlatforms = map[string]string{
+	"linux/amd64":   "linux-x86_64",
+	"linux/arm64":   "linux-aarch64",
+	"darwin/amd64":  "macos-x86_64",
+	"darwin/arm64":  "macos-aarch64",
+	"windows/amd64": "windows-x86_64",
+}
+
+// SHA-256 checksums of the styluaVersion release archives by platform.
+// Update them together with the version: `sha256sum stylua-*.zip`.
+// Platforms without one install StyLua with LuaRocks instead.
+var styluaChecksums = map[string]string{}
+
+var errNoStyluaRelease = errors.New("no StyLua release with a known checksum")
+
+// Names of StyLua configs
+var styluaConfigFiles = []string{"stylua.toml", ".stylua.toml"}
+
+var fmtCheck bool
+
+func init() {
+	rootCmd.AddCommand(fmtCmd)
+
+	fmtCmd.Flags().BoolVar(
+		&fmtCheck,
+		"check",
+		false,
+		"Don't change files, fail and print a diff if they aren't formatted",
+	)
+}
+
+var fmtCmd = &cobra.Command{
+	Use:   "fmt [path...]",
+	Short: "Format the project's Lua code",
+	Long: `Format Lua files with StyLua, in KOReader's style unless the project has
+a stylua.toml of its own. The koreader submodule and vendored rocks are left alone.
+
+StyLua is looked for in PATH. If it isn't there, a pinned release is downloaded
+when kopl knows its checksum for the platform, otherwise it's installed with LuaRocks.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := fmtImpl(args)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// StyLua explained what's wrong itself
+			os.Exit(exitErr.ExitCode())
+		}
+		if err != nil {
+			internalerror.ErrorExit(err)
+		}
+	},
+}
+
+func fmtImpl(paths []string) error {
+	styluaPath, err := ensureStylua()
+	if err != nil {
+		return err
+	}
+
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	// Every path is formatted, even if StyLua fails on one
+	var failed error
+	for _, p := range paths {
+		err = runStylua(styluaPath, p)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			failed = err
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return failed
+}
+
+// runStylua formats target with the config and vendor directory of the
+// plugin it's in.
+func runStylua(styluaPath string, target string) error {
+	dir := target
+	if info, err := os.Stat(target); err == nil && !info.IsDir() {
+		dir = filepath.Dir(target)
+	}
+
+	vendorDir := koplugin.DefaultVendorDir
+	if configPath := koplugin.FindUp(dir, koplugin.ConfigFile); configPath != "" {
+		config, err := koplugin.ReadConfig(filepath.Dir(configPath))
+		if err != nil {
+			return err
+		}
+		vendorDir = config.Vendor()
+	}
+	args := []string{
+		"--glob", "**/*.lua",
+		"--glob", "!**/koreader/**",
+		"--glob", "!**/" + filepath.ToSlash(vendorDir) + "/**",
+	}
+	if fmtCheck {
+		args = append(args, "--check")
+	}
+
+	configArgs, cleanup, err := styluaConfigArgs(dir)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	args = append(args, configArgs...)
+
+	stylua := exec.Command(styluaPath, append(args, target)...)
+	stylua.Stdout = os.Stdout
+	stylua.Stderr = os.Stderr
+	return stylua.Run()
+}
+
+// styluaConfigArgs points StyLua at the config closest to dir, up to the
+// repository's root, or at KOReader's style if there's none, writing it to a
+// temporary file removed by cleanup.
+func styluaConfigArgs(dir string) (args []string, cleanup func(), err error) {
+	cleanup = func() {}
+	if config := koplugin.FindUp(dir, styluaConfigFiles...); config != "" {
+		return []string{"--config-path", config}, cleanup, nil
+	}
+
+	path, cleanup, err := writeTempConfig(styluaConfigFiles[0], func(w io.Writer) error {
+		return luatemplates.StyluaTemplate.Execute(w, nil)
+	})
+	if err != nil {
+		return nil, cleanup, err
+	}
+	return []string{"--config-path", path}, cleanup, nil
+}
+
+// ensureStylua returns the path of StyLua, downloading it into kopl's data
+// directory if it isn't in PATH, or installing it with LuaRocks if there's
+// no release kopl can verify for the platform.
+func ensureStylua() (string, error) {
+	stylua, err := exec.LookPath("stylua")
+	if err == nil {
+		return stylua, nil
+	}
+
+	release, checksum, err := styluaRelease(runtime.GOOS, runtime.GOARCH)
+	if errors.Is(err, errNoStyluaRelease) {
+		err = ensureRock("stylua", "stylua")
+		if err != nil {
+			return "", err
+		}
+		return exec.LookPath("stylua")
+	}
+
+	dataDir, err := utils.DataDir()
+	if err != nil {
+		return "", err
+	}
+	name := "stylua"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	stylua = filepath.Join(dataDir, "bin", "stylua-"+styluaVersion, name)
+	if _, err := os.Stat(stylua); err == nil {
+		return stylua, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "StyLua not found. Downloading StyLua %s...\n", styluaVersion)
+	err = downloadStylua(release, checksum, stylua)
+	if err != nil {
+		return "", fmt.Errorf("while downloading StyLua: %w", err)
+	}
+	return stylua, nil
+}
+
+// styluaRelease returns the name of the styluaVersion release archive for
+// a platform and its checksum, or errNoStyluaRelease if it can't be
+// downloaded.
+func styluaRelease(goos string, goarch string) (string, string, error) {
+	platform, ok := styluaPlatforms[goos+"/"+goarch]
+	if !ok {
+		return "", "", fmt.Errorf("%w for %s/%s", errNoStyluaRelease, goos, goarch)
+	}
+	checksum, ok := styluaChecksums[platform]
+	if !ok {
+		return "", "", fmt.Errorf("%w for %s", errNoStyluaRelease, platform)
+	}
+	return platform, checksum, nil
+}
+
+func downloadStylua(platform string, checksum string, dest string) error {
+	url := fmt.Sprintf(
+		"https://github.com/JohnnyMorganz/StyLua/releases/download/%s/stylua-%s.zip",
+		styluaVersion,
+		platform,
+	)
+	res, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, res.Status)
+	}
+	archive, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(archive)
+	if hex.EncodeToString(sum[:]) != checksum {
+		return fmt.Errorf("%s doesn't match the known checksum", url)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return err
+	}
+	binary, err := reader.Open(filepath.Base(dest))
+	if err != nil {
+		return err
+	}
+	defer binary.Close()
+
+	err = os.MkdirAll(filepath.Dir(dest), 0o755)
+	if err != nil {
+		return err
+	}
+	// Written under another name first, so that a partial download isn't used
+	tmp := dest + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, binary)
+	f.Close()
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
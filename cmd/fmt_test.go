@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestStyluaChecksums(t *testing.T) {
+	platforms := map[string]bool{}
+	for _, platform := range styluaPlatforms {
+		platforms[platform] = true
+	}
+	for platform, checksum := range styluaChecksums {
+		if !platforms[platform] {
+			t.Errorf("checksum for unknown platform %s", platform)
+		}
+		if sum, err := hex.DecodeString(checksum); err != nil || len(sum) != 32 {
+			t.Errorf("checksum for %s isn't a SHA-256: %q", platform, checksum)
+		}
+	}
+
+	// The current platform can get StyLua one way or another
+	_, _, err := styluaRelease(runtime.GOOS, runtime.GOARCH)
+	if err != nil && !errors.Is(err, errNoStyluaRelease) {
+		t.Errorf("got %v, want a release or a LuaRocks install", err)
+	}
+}
+
+func TestStyluaRelease(t *testing.T) {
+	checksums := styluaChecksums
+	defer func() { styluaChecksums = checksums }()
+	styluaChecksums = map[string]string{"linux-x86_64": "abc"}
+
+	tests := []struct {
+		goos     string
+		goarch   string
+		platform string
+		ok       bool
+	}{
+		{"linux", "amd64", "linux-x86_64", true},
+		// Released, but with no known checksum
+		{"darwin", "arm64", "", false},
+		{"linux", "riscv64", "", false},
+	}
+	for _, test := range tests {
+		platform, checksum, err := styluaRelease(test.goos, test.goarch)
+		if test.ok {
+			if err != nil || platform != test.platform || checksum != "abc" {
+				t.Errorf("%s/%s: got %q, %q, %v", test.goos, test.goarch, platform, checksum, err)
+			}
+		} else if !errors.Is(err, errNoStyluaRelease) {
+			t.Errorf("%s/%s: got %v, want errNoStyluaRelease", test.goos, test.goarch, err)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"unicode"
 
 	internalerror "github.com/Consoleaf/kopl/internal_error"
+	"github.com/Consoleaf/kopl/koplugin"
 	"github.com/Consoleaf/kopl/luatemplates"
 	git "github.com/go-git/go-git/v5"
 	"github.com/spf13/cobra"
@@ -53,12 +54,15 @@ var initCmd = &cobra.Command{
 		_ = repo
 		vars := luatemplates.TemplateArgsForInit{
 			ProjectName: convertToPascalCase(projectName),
+			VendorDir:   koplugin.DefaultVendorDir,
 		}
 
 		writeTemplate(luatemplates.MetaFileTemplate, vars)
 		writeTemplate(luatemplates.MainFileTemplate, vars)
 		writeTemplate(luatemplates.LuaRcTemplate, vars)
 		writeTemplate(luatemplates.IgnoreTemplate, vars)
+		writeTemplate(luatemplates.LuacheckTemplate, vars)
+		writeTemplate(luatemplates.StyluaTemplate, vars)
 
 		submoduleCmd := exec.Command("git", "submodule", "add", "--depth", "1", "https://github.com/koreader/koreader.git")
 		submoduleCmd.Stdout = os.Stdout
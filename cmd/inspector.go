@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"os"
 	"strconv"
+	"time"
 
 	koreaderinspector "github.com/Consoleaf/koreader-http-inspector"
 	"github.com/spf13/cobra"
@@ -78,3 +79,36 @@ func restartKOReader() {
 
 	Inspector.RestartKOReader()
 }
+
+// waitForKOReader polls HTTP Inspector until the instance KOReader was
+// restarted from has gone down and a new one answers. The old one keeps
+// answering for a while after the restart was requested, which would pass
+// for the new one before it has loaded anything.
+func waitForKOReader(timeout time.Duration) error {
+	fmt.Println("Waiting for KOReader to come back...")
+
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := Inspector.Get("ui/name")
+		if err != nil {
+			logger.Debug("KOReader went down", "err", err)
+			break
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("KOReader didn't restart within %v", timeout)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	for time.Now().Before(deadline) {
+		time.Sleep(2 * time.Second)
+
+		_, err := Inspector.Get("ui/name")
+		if err == nil {
+			return nil
+		}
+		logger.Debug("KOReader isn't up yet", "err", err)
+	}
+
+	return fmt.Errorf("KOReader didn't come back within %v after restart", timeout)
+}
@@ -8,7 +8,7 @@ import (
 	"strings"
 
 	"github.com/go-git/go-git/v5"
-	"github.com/pkg/sftp"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/spf13/cobra"
 	"github.com/xyproto/randomstring"
 )
@@ -17,75 +17,46 @@ func init() {
 	rootCmd.AddCommand(installCmd)
 	AddInspectorArgs(installCmd)
 	AddSSHFlags(installCmd)
-
-	installCmd.Flags().StringVarP(
-		&deployPath,
-		"deploy-path",
-		"d",
-		"/mnt/us/koreader/plugins",
-		"Path to the koreader directory on device. Defaults to /mnt/us/koreader",
-	)
+	AddDeployFlags(installCmd)
+	AddIndexFlags(installCmd)
 }
 
 var installCmd = &cobra.Command{
-	Use:   "install",
+	Use:   "install <repo|name>",
 	Short: "Install a remotely hosted koplugin",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		remoteRepo := args[0]
-		err := installImpl(remoteRepo)
+		remoteRepo, version, err := resolveIndexName(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = installImpl(remoteRepo, version)
 		if err != nil {
 			log.Fatal(err)
 		}
 	},
 }
 
-func installImpl(remoteRepo string) error {
+// installImpl installs the plugin hosted in remoteRepo at the tag version,
+// or its default branch if version is empty.
+func installImpl(remoteRepo string, version string) error {
 	InitializeInspector()
 
-	success := false
-
-	defer func(success *bool) {
-		if *success {
-			restartKOReader()
-		}
-	}(&success)
-
-	revert, err := makeRevertSSHAllowNoPassword()
-	if err != nil {
-		return err
-	}
-	defer revert()
-
-	conn, err := connectSSH()
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-
-	sftp, err := sftp.NewClient(conn)
-	if err != nil {
-		return err
-	}
-	defer sftp.Close()
-
 	randomstring.Seed()
 	tmp := path.Join(os.TempDir(), randomstring.HumanFriendlyEnglishString(5))
 
-	localRepoPath := strings.TrimSuffix(path.Join(tmp, path.Base(remoteRepo)), ".git")
-	if !strings.HasSuffix(localRepoPath, ".koplugin") {
-		localRepoPath = localRepoPath + ".koplugin"
-	}
+	localRepoPath := path.Join(tmp, pluginDirName(remoteRepo))
+	url := repoURL(remoteRepo)
 
-	url := remoteRepo
-	if !strings.HasPrefix(url, "http") {
-		url = "https://github.com/" + url
+	cloneOptions := &git.CloneOptions{URL: url}
+	if version != "" {
+		cloneOptions.ReferenceName = plumbing.NewTagReferenceName(version)
+		cloneOptions.SingleBranch = true
+		logger.Info(fmt.Sprintf("Cloning '%s' %s into '%s'...", url, version, localRepoPath))
+	} else {
+		logger.Info(fmt.Sprintf("Cloning '%s' into '%s'...", url, localRepoPath))
 	}
-
-	logger.Info(fmt.Sprintf("Cloning '%s' into '%s'...", url, localRepoPath))
-	_, err = git.PlainClone(localRepoPath, false, &git.CloneOptions{
-		URL: url,
-	})
+	_, err := git.PlainClone(localRepoPath, false, cloneOptions)
 	if err != nil {
 		return err
 	}
@@ -94,11 +65,27 @@ func installImpl(remoteRepo string) error {
 		os.RemoveAll(localRepoPath)
 	}()
 
-	logger.Info(fmt.Sprintf("Uploading '%s' to the device...", localRepoPath))
-	err = UploadDirectory(localRepoPath, deployPath, sftp)
+	err = assertLooksLikePlugin(localRepoPath)
 	if err != nil {
 		return err
 	}
-	success = true
-	return nil
+
+	return deployAtomically(localRepoPath)
+}
+
+// repoURL expands "owner/repo" into a GitHub URL.
+func repoURL(repo string) string {
+	if strings.HasPrefix(repo, "http") {
+		return repo
+	}
+	return "https://github.com/" + repo
+}
+
+// pluginDirName is the directory a plugin hosted in repo is installed into.
+func pluginDirName(repo string) string {
+	name := strings.TrimSuffix(path.Base(repo), ".git")
+	if !strings.HasSuffix(name, ".koplugin") {
+		name = name + ".koplugin"
+	}
+	return name
 }
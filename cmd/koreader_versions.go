@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Consoleaf/kopl/lint"
+	"github.com/Consoleaf/kopl/utils"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const koreaderRepoURL = "https://github.com/koreader/koreader.git"
+
+// koreaderTree returns the sources of a KOReader release, cloning them into
+// kopl's data directory the first time.
+func koreaderTree(version string) (lint.KOReaderTree, error) {
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	tree := lint.KOReaderTree{Name: "KOReader " + version}
+
+	dataDir, err := utils.DataDir()
+	if err != nil {
+		return tree, err
+	}
+	tree.Dir = filepath.Join(dataDir, "koreader", version)
+	if _, err := os.Stat(tree.Dir); err == nil {
+		return tree, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Fetching KOReader %s...\n", version)
+	// Cloned next to the final location, so that an interrupted clone isn't reused
+	tmp := tree.Dir + ".tmp"
+	_ = os.RemoveAll(tmp)
+	_, err = git.PlainClone(tmp, false, &git.CloneOptions{
+		URL:           koreaderRepoURL,
+		ReferenceName: plumbing.NewTagReferenceName(version),
+		SingleBranch:  true,
+		Depth:         1,
+	})
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+		return tree, fmt.Errorf("while fetching KOReader %s: %w", version, err)
+	}
+	return tree, os.Rename(tmp, tree.Dir)
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Consoleaf/kopl/luatemplates"
+)
+
+const (
+	luacheckConfigFile = ".luacheckrc"
+	// Lines around the blocks kopl manages in Lua files
+	managedBlockBegin = "-- BEGIN kopl"
+	managedBlockEnd   = "-- END kopl"
+)
+
+// The std setting of a user's config replaces the managed block's one
+var luacheckStdSetting = regexp.MustCompile(`(?m)^\s*std\s*=\s*"([^"]*)"`)
+
+// koreaderLuacheckBlock is the part of .luacheckrc kopl manages.
+func koreaderLuacheckBlock() (string, error) {
+	var buf bytes.Buffer
+	err := luatemplates.LuacheckTemplate.Execute(&buf, nil)
+	return buf.String(), err
+}
+
+// mergeLuacheckConfig puts block into an existing config. An outdated block
+// is replaced in place, otherwise it goes on top, so that the user's own
+// settings override it.
+func mergeLuacheckConfig(config string, block string) string {
+	if merged, ok := replaceManagedBlock(config, block); ok {
+		return merged
+	}
+	if config == "" {
+		return block
+	}
+	return block + "\n" + config
+}
+
+// replaceManagedBlock replaces the lines from BEGIN to END kopl in src
+// with block. Reports false if src has no such block.
+func replaceManagedBlock(src string, block string) (string, bool) {
+	begin := strings.Index(src, managedBlockBegin)
+	end := strings.Index(src, managedBlockEnd)
+	if begin < 0 || end < begin {
+		return src, false
+	}
+	end += len(managedBlockEnd)
+	// The rest of the END line
+	if newline := strings.IndexByte(src[end:], '\n'); newline >= 0 {
+		end += newline + 1
+	} else {
+		end = len(src)
+	}
+	return src[:begin] + block + src[end:], true
+}
+
+// initLuacheckConfig writes KOReader's defaults into the .luacheckrc in dir,
+// keeping what the user added to it.
+func initLuacheckConfig(dir string) error {
+	block, err := koreaderLuacheckBlock()
+	if err != nil {
+		return err
+	}
+
+	configPath := filepath.Join(dir, luacheckConfigFile)
+	existing, err := os.ReadFile(configPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	merged := mergeLuacheckConfig(string(existing), block)
+	if merged == string(existing) {
+		fmt.Fprintf(os.Stderr, "%s is up to date\n", luacheckConfigFile)
+		return nil
+	}
+	err = os.WriteFile(configPath, []byte(merged), 0o644)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote KOReader's defaults to %s\n", luacheckConfigFile)
+
+	// `std` of the user's config replaces the one including KOReader's globals
+	userConfig := strings.Replace(merged, block, "", 1)
+	if match := luacheckStdSetting.FindStringSubmatch(userConfig); match != nil && !strings.Contains(match[1], "koreader") {
+		fmt.Fprintf(
+			os.Stderr,
+			"WARNING: %s sets std = \"%s\". Use \"%s+koreader\" to keep KOReader's globals.\n",
+			luacheckConfigFile,
+			match[1],
+			match[1],
+		)
+	}
+	return nil
+}
+
+// luacheckConfigArgs returns the luacheck arguments to use KOReader's
+// defaults if the project in dir has no .luacheckrc, writing them to a
+// temporary file removed by cleanup.
+func luacheckConfigArgs(dir string) (args []string, cleanup func(), err error) {
+	cleanup = func() {}
+
+	existing, err := os.ReadFile(filepath.Join(dir, luacheckConfigFile))
+	if err == nil {
+		block, err := koreaderLuacheckBlock()
+		if err != nil {
+			return nil, cleanup, err
+		}
+		if mergeLuacheckConfig(string(existing), block) != string(existing) {
+			fmt.Fprintf(
+				os.Stderr,
+				"WARNING: %s doesn't have kopl's current KOReader defaults. Run `kopl check --init-config` to add them.\n",
+				luacheckConfigFile,
+			)
+		}
+		return nil, cleanup, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, cleanup, err
+	}
+
+	block, err := koreaderLuacheckBlock()
+	if err != nil {
+		return nil, cleanup, err
+	}
+	path, cleanup, err := writeTempConfig(luacheckConfigFile, func(w io.Writer) error {
+		_, err := io.WriteString(w, block)
+		return err
+	})
+	if err != nil {
+		return nil, cleanup, err
+	}
+	return []string{"--config", path}, cleanup, nil
+}
+
+// writeTempConfig writes a default config for a tool into a temporary file
+// named like name, for projects without one. cleanup removes it.
+func writeTempConfig(name string, write func(io.Writer) error) (path string, cleanup func(), err error) {
+	f, err := os.CreateTemp("", "kopl-*-"+name)
+	if err != nil {
+		return "", func() {}, err
+	}
+	defer f.Close()
+	cleanup = func() { _ = os.Remove(f.Name()) }
+
+	err = write(f)
+	if err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+	return f.Name(), cleanup, nil
+}
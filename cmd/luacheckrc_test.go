@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeLuacheckConfig(t *testing.T) {
+	block := "-- BEGIN kopl: defaults\nstd = \"luajit+koreader\"\n-- END kopl\n"
+	outdated := "-- BEGIN kopl: defaults\nstd = \"luajit\"\n-- END kopl\n"
+
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{"no config", "", block},
+		{
+			"config without the block",
+			"globals = { \"MyGlobal\" }\n",
+			block + "\nglobals = { \"MyGlobal\" }\n",
+		},
+		{
+			"user settings override the block",
+			"std = \"max+koreader\"\nread_globals = { \"Device\" }\n",
+			block + "\nstd = \"max+koreader\"\nread_globals = { \"Device\" }\n",
+		},
+		{
+			"outdated block is replaced in place",
+			"-- Mine\n" + outdated + "globals = { \"MyGlobal\" }\n",
+			"-- Mine\n" + block + "globals = { \"MyGlobal\" }\n",
+		},
+		{
+			"block at the end without a newline",
+			"globals = {}\n" + strings.TrimSuffix(outdated, "\n"),
+			"globals = {}\n" + block,
+		},
+		{"up to date", block + "globals = {}\n", block + "globals = {}\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := mergeLuacheckConfig(test.config, block); got != test.want {
+				t.Errorf("got\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestInitLuacheckConfig(t *testing.T) {
+	block, err := koreaderLuacheckBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		existing *string
+		want     string
+	}{
+		{"no file", nil, block},
+		{"existing file", ptr("globals = { \"MyGlobal\" }\n"), block + "\nglobals = { \"MyGlobal\" }\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			configPath := filepath.Join(dir, luacheckConfigFile)
+			if test.existing != nil {
+				err := os.WriteFile(configPath, []byte(*test.existing), 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := initLuacheckConfig(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLuacheckConfigArgs(t *testing.T) {
+	dir := t.TempDir()
+
+	args, cleanup, err := luacheckConfigArgs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 2 || args[0] != "--config" {
+		t.Fatalf("without a config got %v, want a temporary one", args)
+	}
+	block, _ := koreaderLuacheckBlock()
+	if got, _ := os.ReadFile(args[1]); string(got) != block {
+		t.Errorf("temporary config is\n%s\nwant\n%s", got, block)
+	}
+	cleanup()
+	if _, err := os.Stat(args[1]); !os.IsNotExist(err) {
+		t.Errorf("cleanup left %s", args[1])
+	}
+
+	err = os.WriteFile(filepath.Join(dir, luacheckConfigFile), []byte(block), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, cleanup, err = luacheckConfigArgs(dir)
+	defer cleanup()
+	if err != nil || args != nil {
+		t.Errorf("with a config got %v, %v, want no arguments", args, err)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
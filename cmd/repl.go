@@ -1,11 +1,13 @@
 package cmd
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/chzyer/readline"
@@ -24,6 +26,10 @@ func init() {
 	rootCmd.AddCommand(replCmd)
 	AddInspectorArgs(replCmd)
 	AddSSHFlags(replCmd)
+	AddHistoryFlags(replCmd)
+	AddTransportFlags(replCmd)
+	AddBridgeFlags(replCmd)
+	AddStreamFlags(replCmd)
 }
 
 var replCmd = &cobra.Command{
@@ -43,88 +49,182 @@ func main(_ *cobra.Command, _ []string) error {
 		quitCommand = "quit"
 	)
 
-	InitializeInspector()
-
-	err := ensureReplPlugin()
+	err := connectRepl()
 	if err != nil {
 		return err
 	}
+	defer transport.Close()
 
 	fmt.Printf(
-		"KOReader Lua REPL. Type '%s' or '%s' to exit.\n",
+		"KOReader Lua REPL. Type '%s' or '%s' to exit, ':help' for commands.\n",
 		exitCommand,
 		quitCommand,
 	)
 
+	history, err := historyFile()
+	if err != nil {
+		return err
+	}
+	logger.Debug("REPL history", "file", history)
+
 	rl, err := readline.NewEx(&readline.Config{
-		HistoryFile:     "/tmp/readline.tmp",
+		HistoryFile:     history,
+		HistoryLimit:    historyLimit,
 		InterruptPrompt: "^C",
 		EOFPrompt:       "exit",
 
 		HistorySearchFold: true,
+		AutoComplete:      newReplCompleter(),
 	})
 	if err != nil {
 		panic(err)
 	}
 	defer rl.Close()
-	rl.CaptureExitSignal()
 
-	var incomplete bool
+	session := &replSession{
+		rl:     rl,
+		format: replFormat{Depth: 2},
+		stream: streamOutput,
+	}
+	if session.stream && !hasCapability("stream") {
+		logger.Warn("The Lua runtime on the device can't stream output, printing it once code is done")
+		session.stream = false
+	}
+	session.watchSignals()
+
+	// Lines of a chunk that isn't complete yet
+	var buffer []string
 	for {
-		if incomplete {
+		if len(buffer) > 0 {
 			rl.SetPrompt(continuePromptStyle.Render("... "))
 		} else {
 			rl.SetPrompt(initialPromptStyle.Render(">>> "))
 		}
 
 		input, err := rl.Readline()
+		if err == readline.ErrInterrupt {
+			if len(buffer) > 0 {
+				fmt.Println("Cancelled incomplete chunk.")
+				buffer = nil
+			}
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			return err
 		}
 
-		if input == exitCommand || input == quitCommand {
+		if len(buffer) == 0 && (input == exitCommand || input == quitCommand) {
 			fmt.Println("Exiting REPL.")
 			break
 		}
 
-		if strings.Trim(input, "") == "" {
+		if len(buffer) == 0 && strings.HasPrefix(input, ":") {
+			err := session.runCommand(input)
+			if err != nil {
+				logger.Error(err.Error())
+			}
 			continue
 		}
 
-		result, out, complete, err := Evaluate(input)
-		incomplete = !complete
-		if err != nil {
-			logger.Error(err.Error())
-		} else if !complete {
-		} else {
-			if len(out) > 0 {
-				fmt.Println("[OUT] " + strings.Join(out, "\n[OUT] "))
-			}
-			if result != "<nil>" {
-				fmt.Printf("[RET] %v\n", result)
+		if strings.TrimSpace(input) == "" {
+			// A blank line cancels an incomplete chunk
+			if len(buffer) > 0 {
+				fmt.Println("Cancelled incomplete chunk.")
+				buffer = nil
 			}
+			continue
+		}
+
+		buffer = append(buffer, input)
+		if !session.evaluate(strings.Join(buffer, "\n"), false) {
+			continue
 		}
+		buffer = nil
 	}
 
 	return nil
 }
 
+// replSession is the state of an interactive REPL.
+type replSession struct {
+	rl     *readline.Instance
+	format replFormat
+	// Complete chunks evaluated during the session
+	inputs []string
+	// stream prints output while code runs
+	stream bool
+
+	mu sync.Mutex
+	// interrupt stops the running stream, if any
+	interrupt func()
+}
+
+// evaluate runs code and prints its output and return values.
+// Returns false if code isn't a complete chunk yet.
+func (s *replSession) evaluate(code string, timed bool) bool {
+	var result *evalResult
+	var out []string
+	var err error
+	if s.stream {
+		result, err = s.evaluateStreaming(code)
+	} else {
+		result, out, err = EvaluateStructured(code, s.format.Depth)
+	}
+	if err == nil && result.Error != nil && result.Error.Code == ErrIncomplete {
+		return false
+	}
+	s.inputs = append(s.inputs, code)
+
+	if len(out) > 0 {
+		fmt.Println("[OUT] " + strings.Join(out, "\n[OUT] "))
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		return true
+	}
+
+	if result.Error != nil {
+		logger.Error(result.Error.Error())
+		if details := result.Error.details(code); details != "" {
+			fmt.Println(details)
+		}
+	} else {
+		for _, value := range result.Values {
+			fmt.Printf("[RET] %s\n", s.format.render(value))
+		}
+	}
+	if timed {
+		elapsed := time.Duration(result.Elapsed * float64(time.Second)).Round(time.Microsecond)
+		if hasCapability("timing") {
+			fmt.Printf("[TIME] %s\n", elapsed)
+		} else {
+			fmt.Printf("[TIME] %s (CPU time)\n", elapsed)
+		}
+	}
+	return true
+}
+
 type ReplResponse struct {
 	Error  string        `json:"error"`
 	Return string        `json:"ret"`
 	Output outputStrings `json:"out"`
 }
 
-func Evaluate(code string) (any, []string, bool, error) {
-	ret, err := Inspector.Get("/ui/Repl/repl/" + base64.StdEncoding.EncodeToString([]byte(code)))
+// Evaluate runs a chunk on the device and returns what it returned and
+// printed. Errors raised by the chunk are returned as *ReplError.
+func Evaluate(code string) (any, []string, error) {
+	ret, err := transport.Send(code)
 	if err != nil {
-		return "", []string{}, true, err
+		return "", []string{}, err
 	}
 
 	var _response []ReplResponse
 	err = json.Unmarshal(ret, &_response)
 	if err != nil {
-		return "", []string{}, true, fmt.Errorf(
+		return "", []string{}, fmt.Errorf(
 			"couldn't parse response: %v\nValue: %v",
 			err,
 			string(ret),
@@ -134,13 +234,10 @@ func Evaluate(code string) (any, []string, bool, error) {
 	response := _response[0]
 
 	if response.Error != "" {
-		if response.Error == "code is incomplete" {
-			return "", []string{}, false, nil
-		}
-		return "", []string{}, true, fmt.Errorf("error from REPL: %v", response.Error)
+		return "", response.Output, &ReplError{Code: ErrRuntime, Message: response.Error}
 	}
 
-	return response.Return, response.Output, true, nil
+	return response.Return, response.Output, nil
 }
 
 // We need this because if an empty table is sent from Lua, it'll be encoded as {}
@@ -170,6 +267,30 @@ func (o *outputStrings) UnmarshalJSON(data []byte) error {
 	return fmt.Errorf("unexpected type for OutputStrings: %s", data)
 }
 
+// connectRepl sets up the channel code is evaluated through: either HTTP
+// Inspector with repl.koplugin, or koplbridge.koplugin over SSH.
+func connectRepl() error {
+	InitializeInspector()
+
+	if useBridge {
+		err := setupBridgeTransport()
+		if err != nil {
+			return err
+		}
+		return negotiateProtocol()
+	}
+
+	err := ensureReplPlugin()
+	if err != nil {
+		return err
+	}
+	err = setupReplTransport()
+	if err != nil {
+		return err
+	}
+	return negotiateProtocol()
+}
+
 func ensureReplPlugin() error {
 	logger.Warn("Checking repl.koplugin")
 	res, err := Inspector.Get("ui/Repl/fullname")
@@ -183,14 +304,15 @@ func ensureReplPlugin() error {
 		logger.Warn("repl.koplugin is outdated. Updating...")
 	}
 	logger.Info("Installing 'consoleaf/repl.koplugin'")
-	return installImpl("consoleaf/repl.koplugin")
+	return installImpl("consoleaf/repl.koplugin", "")
 }
 
 func isPluginOutdated() bool {
-	res, _ := Inspector.Get("ui/Repl/version")
-	parsed := string(res)
-	if strings.Contains(parsed, "Res: No such table/object key: version") {
+	res, err := Inspector.Get("ui/Repl/version")
+	version := string(res)
+	// Versions before v0.0.3 don't declare one
+	if err != nil || !semver.IsValid(version) {
 		return true
 	}
-	return semver.Compare(parsed, MinimalRequiredReplKoplugin) < 0
+	return semver.Compare(version, MinimalRequiredReplKoplugin) < 0
 }
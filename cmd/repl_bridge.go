@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/Consoleaf/kopl/luahelpers"
+	"github.com/pkg/sftp"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+var useBridge bool
+
+func AddBridgeFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(
+		&useBridge,
+		"bridge",
+		false,
+		"Evaluate code through koplbridge.koplugin over SSH instead of HTTP Inspector. Needs --ssh-port",
+	)
+	AddDeployPathFlag(cmd)
+}
+
+// bridgeTransport sends chunks to koplbridge.koplugin through a connection
+// forwarded over SSH.
+type bridgeTransport struct {
+	conn *ssh.Client
+}
+
+func (t *bridgeTransport) Send(code string) ([]byte, error) {
+	c, err := t.conn.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", luahelpers.BridgePort))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't reach koplbridge.koplugin: %w", err)
+	}
+	defer c.Close()
+
+	_, err = fmt.Fprintf(c, "%d\n%s", len(code), code)
+	if err != nil {
+		return nil, err
+	}
+	// The bridge closes the connection after responding
+	return io.ReadAll(c)
+}
+
+func (t *bridgeTransport) Close() {
+	t.conn.Close()
+}
+
+// setupBridgeTransport connects to the standalone SSH server and makes sure
+// the bridge plugin is installed.
+func setupBridgeTransport() error {
+	if SSHPort == 0 {
+		return fmt.Errorf("--bridge works without HTTP Inspector, so it needs a standalone SSH server. Pass --ssh-port")
+	}
+
+	conn, err := connectSSH()
+	if err != nil {
+		return err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	updated, err := installBridgePlugin(client)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if updated {
+		conn.Close()
+		return fmt.Errorf(
+			"installed %s to %s. Restart KOReader on the device to load it, then try again",
+			luahelpers.BridgePluginDir,
+			deployPath,
+		)
+	}
+
+	transport = &bridgeTransport{conn: conn}
+	return nil
+}
+
+// installBridgePlugin uploads the bridge plugin if the device has
+// a different version of it. Returns whether it did.
+func installBridgePlugin(client *sftp.Client) (bool, error) {
+	var files []string
+	err := fs.WalkDir(luahelpers.BridgePlugin, luahelpers.BridgePluginDir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, p)
+		}
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	updated := false
+	for _, file := range files {
+		local, err := fs.ReadFile(luahelpers.BridgePlugin, file)
+		if err != nil {
+			return false, err
+		}
+		remotePath := path.Join(deployPath, file)
+
+		remote, err := readRemoteFile(client, remotePath)
+		if err == nil && bytes.Equal(remote, local) {
+			continue
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+
+		err = client.MkdirAll(path.Dir(remotePath))
+		if err != nil {
+			return false, err
+		}
+		f, err := client.Create(remotePath)
+		if err != nil {
+			return false, err
+		}
+		_, err = f.Write(local)
+		f.Close()
+		if err != nil {
+			return false, err
+		}
+		logger.Info(fmt.Sprintf("Uploaded %s", remotePath))
+		updated = true
+	}
+	return updated, nil
+}
+
+func readRemoteFile(client *sftp.Client, remotePath string) ([]byte, error) {
+	f, err := client.Open(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Consoleaf/kopl/luahelpers"
+)
+
+// replCommand is a colon command of the REPL, like `:help`.
+type replCommand struct {
+	Name string
+	Args string
+	Help string
+	// RawArgs passes the rest of the line as a single argument
+	RawArgs bool
+	Run     func(s *replSession, args []string) error
+}
+
+var replCommands []replCommand
+
+// Assigned in init, since :help refers to replCommands
+func init() {
+	replCommands = []replCommand{
+		{
+			Name: ":help",
+			Help: "Show this help",
+			Run: func(_ *replSession, _ []string) error {
+				for _, command := range replCommands {
+					usage := command.Name
+					if command.Args != "" {
+						usage += " " + command.Args
+					}
+					fmt.Printf("  %-20s %s\n", usage, command.Help)
+				}
+				return nil
+			},
+		},
+		{
+			Name: ":load",
+			Args: "<file.lua>",
+			Help: "Evaluate a local Lua file",
+			Run: func(s *replSession, args []string) error {
+				if len(args) != 1 {
+					return fmt.Errorf("usage: :load <file.lua>")
+				}
+				code, err := os.ReadFile(args[0])
+				if err != nil {
+					return err
+				}
+				if !s.evaluate(string(code), false) {
+					return fmt.Errorf("%s is not a complete chunk", args[0])
+				}
+				return nil
+			},
+		},
+		{
+			Name: ":reload",
+			Args: "<module>",
+			Help: "Unload a module and require it again",
+			Run: func(s *replSession, args []string) error {
+				if len(args) != 1 {
+					return fmt.Errorf("usage: :reload <module>")
+				}
+				module := luahelpers.Quote(args[0])
+				s.evaluate(fmt.Sprintf("package.loaded[%s] = nil\nreturn require(%s)", module, module), false)
+				return nil
+			},
+		},
+		{
+			Name:    ":time",
+			Args:    "<code>",
+			Help:    "Evaluate code and print how long it took on the device",
+			RawArgs: true,
+			Run: func(s *replSession, args []string) error {
+				if len(args) == 0 {
+					return fmt.Errorf("usage: :time <code>")
+				}
+				if !s.evaluate(args[0], true) {
+					return fmt.Errorf("code is incomplete")
+				}
+				return nil
+			},
+		},
+		{
+			Name: ":history",
+			Help: "List the chunks evaluated in this session",
+			Run: func(s *replSession, _ []string) error {
+				for i, input := range s.inputs {
+					fmt.Printf("%3d  %s\n", i+1, strings.ReplaceAll(input, "\n", "\n     "))
+				}
+				return nil
+			},
+		},
+		{
+			Name: ":save",
+			Args: "<file.lua>",
+			Help: "Write the chunks evaluated in this session to a file",
+			Run: func(s *replSession, args []string) error {
+				if len(args) != 1 {
+					return fmt.Errorf("usage: :save <file.lua>")
+				}
+				err := os.WriteFile(args[0], []byte(strings.Join(s.inputs, "\n")+"\n"), 0o644)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Saved %d chunks to %s\n", len(s.inputs), args[0])
+				return nil
+			},
+		},
+		{
+			Name: ":clear",
+			Help: "Clear the screen",
+			Run: func(_ *replSession, _ []string) error {
+				fmt.Print("\033[H\033[2J")
+				return nil
+			},
+		},
+		{
+			Name: ":depth",
+			Args: "[N]",
+			Help: "Show or set how deep returned tables are printed",
+			Run: func(s *replSession, args []string) error {
+				return s.format.setDepth(args)
+			},
+		},
+		{
+			Name: ":raw",
+			Help: "Toggle printing values as tostring() does",
+			Run: func(s *replSession, _ []string) error {
+				s.format.toggleRaw()
+				return nil
+			},
+		},
+		{
+			Name: ":stream",
+			Help: "Toggle printing output while code runs (Ctrl-C interrupts it)",
+			Run: func(s *replSession, _ []string) error {
+				if !s.stream && !hasCapability("stream") {
+					return fmt.Errorf("the Lua runtime on the device can't stream output")
+				}
+				s.stream = !s.stream
+				if s.stream {
+					fmt.Println("Printing output while code runs. Ctrl-C interrupts it.")
+				} else {
+					fmt.Println("Printing output once code is done.")
+				}
+				return nil
+			},
+		},
+	}
+}
+
+func (s *replSession) runCommand(input string) error {
+	fields := strings.Fields(input)
+	for _, command := range replCommands {
+		if command.Name != fields[0] {
+			continue
+		}
+		args := fields[1:]
+		if command.RawArgs && len(args) != 0 {
+			args = []string{strings.TrimSpace(strings.TrimPrefix(input, fields[0]))}
+		}
+		return command.Run(s, args)
+	}
+	return fmt.Errorf("unknown command %s. Type :help for the list of commands", fields[0])
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Consoleaf/kopl/luahelpers"
+)
+
+// Matches the identifier chain before the cursor, e.g. "ui.menu:reg"
+var completionTarget = regexp.MustCompile(`(?:([A-Za-z_]\w*(?:\.[A-Za-z_]\w*)*)([.:]))?([A-Za-z_]\w*)?$`)
+
+// replCompleter completes table keys from KOReader's live Lua state.
+type replCompleter struct {
+	// Keys of already resolved expressions, for the rest of the session
+	cache map[string][]string
+}
+
+func newReplCompleter() *replCompleter {
+	return &replCompleter{cache: map[string][]string{}}
+}
+
+func (c *replCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	match := completionTarget.FindStringSubmatch(string(line[:pos]))
+	if match == nil {
+		return nil, 0
+	}
+	expression, partial := match[1], match[3]
+	if expression == "" {
+		// Globals, including the ones the REPL environment adds, like `ui`
+		expression = "getfenv(1)"
+	}
+
+	var candidates [][]rune
+	for _, key := range c.keys(expression) {
+		if strings.HasPrefix(key, partial) {
+			candidates = append(candidates, []rune(key[len(partial):]))
+		}
+	}
+	return candidates, len([]rune(partial))
+}
+
+func (c *replCompleter) keys(expression string) []string {
+	if keys, ok := c.cache[expression]; ok {
+		return keys
+	}
+
+	result, _, err := EvaluateHelper(luahelpers.Keys, expression)
+	if err != nil {
+		logger.Debug("Completion failed", "expression", expression, "err", err)
+		return nil
+	}
+
+	var keys []string
+	if text, ok := result.(string); ok && text != "" && text != "<nil>" {
+		keys = strings.Split(text, "\n")
+	}
+	c.cache[expression] = keys
+	return keys
+}
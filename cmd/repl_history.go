@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/Consoleaf/kopl/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	noHistory    bool
+	historyScope string
+	historyLimit int
+)
+
+func AddHistoryFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(
+		&noHistory,
+		"no-history",
+		false,
+		"Don't load or save REPL history",
+	)
+	cmd.Flags().StringVar(
+		&historyScope,
+		"history-scope",
+		"global",
+		"Keep separate REPL history per 'project' (current directory), per 'device' (host) or 'global'",
+	)
+	cmd.Flags().IntVar(
+		&historyLimit,
+		"history-limit",
+		1000,
+		"Maximum number of REPL history entries to keep",
+	)
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// historyFile returns the REPL history file for the configured scope,
+// creating its directory. Returns "" if history is disabled.
+func historyFile() (string, error) {
+	if noHistory {
+		return "", nil
+	}
+
+	name := "global"
+	switch historyScope {
+	case "global":
+	case "device":
+		name = "device-" + unsafeFileNameChars.ReplaceAllString(Host, "_")
+	case "project":
+		cwd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		sum := sha256.Sum256([]byte(cwd))
+		name = fmt.Sprintf(
+			"project-%s-%s",
+			unsafeFileNameChars.ReplaceAllString(filepath.Base(cwd), "_"),
+			hex.EncodeToString(sum[:])[:12],
+		)
+	default:
+		return "", fmt.Errorf("invalid history scope %q, expected global, project or device", historyScope)
+	}
+
+	stateDir, err := utils.StateDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(stateDir, "history")
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return "", err
+	}
+
+	// History may contain secrets, so it's only readable by the user.
+	// readline creates it world-readable otherwise.
+	file := filepath.Join(dir, name)
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	err = os.Chmod(file, 0o600)
+	if err != nil {
+		return "", err
+	}
+
+	return file, nil
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Consoleaf/kopl/luahelpers"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	stringStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("#00AA00"))
+	numberStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("#00AAAA"))
+	constantStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#AA5500"))
+	keyStyle      = lipgloss.NewStyle().Bold(true)
+	mutedStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("#888888"))
+)
+
+var luaIdentifier = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+// evalResult is what the Eval helper returns.
+type evalResult struct {
+	// ok or error
+	Status string `json:"status"`
+	// Error is set if the status is error
+	Error  *ReplError `json:"error"`
+	Values []luaValue `json:"values"`
+	// Elapsed is the on-device execution time in seconds
+	Elapsed float64 `json:"elapsed"`
+}
+
+// luaValue is a serialized Lua value. Tables have their fields
+// serialized up to a depth.
+type luaValue struct {
+	Type string `json:"type"`
+	// Repr is tostring() of the value
+	Repr      string     `json:"repr"`
+	Fields    []luaField `json:"fields"`
+	Truncated bool       `json:"truncated"`
+	Cycle     bool       `json:"cycle"`
+}
+
+type luaField struct {
+	Key   luaValue `json:"key"`
+	Value luaValue `json:"value"`
+}
+
+// replFormat controls how the REPL renders returned values.
+type replFormat struct {
+	// Depth up to which tables are serialized
+	Depth int
+	// Raw prints values as tostring() would
+	Raw bool
+}
+
+// EvaluateStructured runs code and returns its return values serialized.
+func EvaluateStructured(code string, depth int) (*evalResult, []string, error) {
+	ret, out, err := EvaluateHelper(luahelpers.Eval, code, strconv.Itoa(depth))
+	if err != nil {
+		return nil, out, err
+	}
+
+	result := &evalResult{}
+	err = decodeHelperResult(ret, result)
+	if err != nil {
+		return nil, out, err
+	}
+	if result.Error != nil {
+		result.Error.locate(code)
+	}
+	return result, out, nil
+}
+
+// decodeHelperResult parses the JSON a helper returned into v.
+func decodeHelperResult(ret any, v any) error {
+	text := fmt.Sprint(ret)
+	// In case the plugin returns strings JSON-encoded
+	if strings.HasPrefix(text, `"`) {
+		_ = json.Unmarshal([]byte(text), &text)
+	}
+
+	err := json.Unmarshal([]byte(text), v)
+	if err != nil {
+		return fmt.Errorf("couldn't parse result: %v\nValue: %v", err, text)
+	}
+	return nil
+}
+
+func (f replFormat) render(value luaValue) string {
+	if f.Raw {
+		return value.Repr
+	}
+	return f.renderPretty(value, "")
+}
+
+func (f replFormat) renderPretty(value luaValue, indent string) string {
+	switch value.Type {
+	case "string":
+		return stringStyle.Render(strconv.Quote(value.Repr))
+	case "number":
+		return numberStyle.Render(value.Repr)
+	case "boolean", "nil":
+		return constantStyle.Render(value.Repr)
+	case "table":
+	default:
+		return mutedStyle.Render(value.Repr)
+	}
+
+	if value.Cycle {
+		return mutedStyle.Render("<cycle " + value.Repr + ">")
+	}
+	if value.Truncated && len(value.Fields) == 0 {
+		return mutedStyle.Render(value.Repr + " {…}")
+	}
+	if len(value.Fields) == 0 {
+		return "{}"
+	}
+
+	inner := indent + "  "
+	var buf strings.Builder
+	buf.WriteString("{\n")
+	for _, field := range value.Fields {
+		buf.WriteString(inner)
+		buf.WriteString(f.renderKey(field.Key))
+		buf.WriteString(" = ")
+		buf.WriteString(f.renderPretty(field.Value, inner))
+		buf.WriteString(",\n")
+	}
+	if value.Truncated {
+		buf.WriteString(inner + mutedStyle.Render("…") + "\n")
+	}
+	buf.WriteString(indent + "}")
+	return buf.String()
+}
+
+func (f replFormat) renderKey(key luaValue) string {
+	if key.Type == "string" && luaIdentifier.MatchString(key.Repr) {
+		return keyStyle.Render(key.Repr)
+	}
+	return "[" + f.renderPretty(key, "") + "]"
+}
+
+func (f *replFormat) setDepth(args []string) error {
+	if len(args) == 0 {
+		fmt.Printf("Depth is %d\n", f.Depth)
+		return nil
+	}
+	depth, err := strconv.Atoi(args[0])
+	if err != nil || depth < 0 {
+		return fmt.Errorf("invalid depth %q", args[0])
+	}
+	f.Depth = depth
+	return nil
+}
+
+func (f *replFormat) toggleRaw() {
+	f.Raw = !f.Raw
+	if f.Raw {
+		fmt.Println("Printing values as tostring() does.")
+	} else {
+		fmt.Println("Pretty-printing values.")
+	}
+}
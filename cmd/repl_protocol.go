@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Consoleaf/kopl/luahelpers"
+	"github.com/Consoleaf/kopl/luasyntax"
+	"golang.org/x/mod/semver"
+)
+
+// Codes of errors raised by evaluated code
+const (
+	ErrSyntax     = "syntax"
+	ErrRuntime    = "runtime"
+	ErrIncomplete = "incomplete"
+)
+
+// ReplError is an error raised by code evaluated on the device.
+type ReplError struct {
+	// Code is ErrSyntax, ErrRuntime or ErrIncomplete
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Line and Column in the evaluated code are 1-based, 0 if unknown
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	// Near is the token a syntax error was found at
+	Near string `json:"near"`
+	// Traceback of a runtime error, innermost frame first
+	Traceback []string `json:"traceback"`
+}
+
+func (e *ReplError) Error() string {
+	switch {
+	case e.Code == ErrIncomplete:
+		return "code is incomplete"
+	case e.Code == ErrSyntax && e.Line > 0:
+		return fmt.Sprintf("syntax error on line %d: %s", e.Line, e.Message)
+	case e.Code == ErrSyntax:
+		return "syntax error: " + e.Message
+	}
+	return "error from REPL: " + e.Message
+}
+
+// locate finds the column of a syntax error from the token Lua reports it
+// near. Lua only reports the line, so the last such token on it is assumed.
+func (e *ReplError) locate(code string) {
+	if e.Code != ErrSyntax || e.Line == 0 || e.Near == "" {
+		return
+	}
+
+	tokens, _ := luasyntax.Tokenize(code)
+	for _, token := range tokens {
+		if token.Line != e.Line || token.Kind == luasyntax.EOF {
+			continue
+		}
+		if token.Value == e.Near || (token.Kind == luasyntax.String && strings.Contains(e.Near, token.Value)) {
+			e.Column = token.Column
+		}
+	}
+	if e.Column != 0 {
+		return
+	}
+
+	// The tokenizer stops at errors like unfinished strings
+	lines := strings.Split(code, "\n")
+	if e.Line <= len(lines) {
+		e.Column = strings.LastIndex(lines[e.Line-1], e.Near) + 1
+	}
+}
+
+// details shows the offending line of code, with a caret under the
+// error's position if it's known, or the traceback.
+func (e *ReplError) details(code string) string {
+	var b strings.Builder
+	lines := strings.Split(code, "\n")
+	if e.Code == ErrSyntax && e.Line > 0 && e.Line <= len(lines) {
+		line := lines[e.Line-1]
+		prefix := fmt.Sprintf("%4d | ", e.Line)
+		fmt.Fprintf(&b, "%s%s", mutedStyle.Render(prefix), line)
+		if e.Column > 0 && e.Column <= len(line)+1 {
+			// Keep tabs, so that the caret lines up
+			var padding strings.Builder
+			for _, r := range line[:e.Column-1] {
+				if r == '\t' {
+					padding.WriteRune('\t')
+				} else {
+					padding.WriteRune(' ')
+				}
+			}
+			fmt.Fprintf(&b, "\n%s%s^", mutedStyle.Render("     | "), padding.String())
+		}
+	}
+
+	for i, frame := range e.Traceback {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(mutedStyle.Render("    " + frame))
+	}
+	return b.String()
+}
+
+// deviceProtocol is what the device reported in the handshake.
+var deviceProtocol struct {
+	// BridgeProtocol of the running bridge plugin, 0 if none runs
+	Bridge int `json:"bridge"`
+	// Repl is the version of the loaded repl.koplugin, empty if unknown
+	Repl         string   `json:"repl"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// negotiateProtocol checks that the plugin evaluating code on the device
+// speaks kopl's protocol and discovers what its Lua runtime supports.
+func negotiateProtocol() error {
+	ret, _, err := EvaluateHelper(luahelpers.Hello)
+	if err != nil {
+		return err
+	}
+	err = decodeHelperResult(ret, &deviceProtocol)
+	if err != nil {
+		return err
+	}
+	logger.Debug(
+		"REPL protocol",
+		"bridge", deviceProtocol.Bridge,
+		"repl", deviceProtocol.Repl,
+		"capabilities", deviceProtocol.Capabilities,
+	)
+	return checkDeviceProtocol(useBridge)
+}
+
+// checkDeviceProtocol fails if the plugin evaluating code on the device
+// is older than the one kopl installs, e.g. because KOReader wasn't
+// restarted after installing it.
+func checkDeviceProtocol(bridge bool) error {
+	if bridge {
+		if deviceProtocol.Bridge != luahelpers.BridgeProtocol {
+			return fmt.Errorf(
+				"%s running on the device speaks protocol version %d, this kopl needs version %d. Restart KOReader to load the installed one",
+				luahelpers.BridgePluginDir,
+				deviceProtocol.Bridge,
+				luahelpers.BridgeProtocol,
+			)
+		}
+		return nil
+	}
+
+	return checkReplVersion(deviceProtocol.Repl)
+}
+
+// checkReplVersion fails if the version of the running repl.koplugin is
+// older than the one whose response format kopl reads.
+func checkReplVersion(version string) error {
+	// Versions before v0.0.3 don't declare one
+	if semver.IsValid(version) && semver.Compare(version, MinimalRequiredReplKoplugin) >= 0 {
+		return nil
+	}
+	if version == "" {
+		version = "of an unknown version"
+	}
+	return fmt.Errorf(
+		"repl.koplugin %s is running on the device, this kopl needs %s or later. Restart KOReader to load the installed one",
+		version,
+		MinimalRequiredReplKoplugin,
+	)
+}
+
+func hasCapability(name string) bool {
+	return slices.Contains(deviceProtocol.Capabilities, name)
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Consoleaf/kopl/luahelpers"
+	"github.com/spf13/cobra"
+)
+
+// How often a running stream is polled for output
+const streamPollInterval = 250 * time.Millisecond
+
+var (
+	streamOutput  bool
+	streamTimeout time.Duration
+)
+
+func AddStreamFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(
+		&streamOutput,
+		"stream",
+		false,
+		"Print output while the code runs and let Ctrl-C interrupt it the next time it prints",
+	)
+	cmd.Flags().DurationVar(
+		&streamTimeout,
+		"timeout",
+		30*time.Second,
+		"Stop streamed code that runs this long without printing, as it blocks KOReader (0 to disable)",
+	)
+}
+
+// streamResponse is what the Stream helper returns.
+type streamResponse struct {
+	// running, done, missing, cancelled or error
+	Status  string        `json:"status"`
+	Message string        `json:"message"`
+	Output  outputStrings `json:"out"`
+	// Result is set once the stream is done
+	Result *evalResult `json:"result"`
+}
+
+// EvaluateStreaming runs code like EvaluateStructured, but passes its output
+// to onOutput as it is printed. Closing interrupt stops the code on the device.
+func EvaluateStreaming(code string, depth int, onOutput func([]string), interrupt <-chan struct{}) (*evalResult, error) {
+	if !hasCapability("stream") {
+		return nil, fmt.Errorf("the Lua runtime on the device can't stream output")
+	}
+	if streamTimeout > 0 && !hasCapability("timeout") {
+		logger.Warn("The Lua runtime on the device can't stop code that doesn't print, --timeout is ignored")
+	}
+
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	id := hex.EncodeToString(buf)
+
+	res, err := streamRequest("start", id, code, depth)
+	if err != nil {
+		return nil, err
+	}
+	if res.Status == "missing" {
+		// Streams run through the Eval helper, so it has to be installed first
+		_, _, err = EvaluateHelper(luahelpers.Eval, "", "0")
+		if err != nil {
+			return nil, err
+		}
+		res, err = streamRequest("start", id, code, depth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		switch res.Status {
+		case "running", "done":
+		default:
+			return nil, fmt.Errorf("unexpected stream status %q: %s", res.Status, res.Message)
+		}
+		if len(res.Output) > 0 {
+			onOutput(res.Output)
+		}
+		if res.Status == "done" {
+			if res.Result == nil {
+				return nil, fmt.Errorf("stream finished without a result")
+			}
+			if res.Result.Error != nil {
+				res.Result.Error.locate(code)
+			}
+			return res.Result, nil
+		}
+
+		select {
+		case <-interrupt:
+			// The device can't serve the request before the code prints
+			logger.Warn("Interrupting the next time the code prints...")
+			_, err = streamRequest("cancel", id, "", depth)
+			if err != nil {
+				return nil, err
+			}
+			// Keep polling to collect the remaining output
+			interrupt = nil
+		case <-time.After(streamPollInterval):
+		}
+
+		res, err = streamRequest("poll", id, "", depth)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func streamRequest(op string, id string, code string, depth int) (*streamResponse, error) {
+	ret, _, err := EvaluateHelper(
+		luahelpers.Stream,
+		op,
+		id,
+		luahelpers.Eval.ID(),
+		code,
+		strconv.Itoa(depth),
+		strconv.Itoa(int(math.Ceil(streamTimeout.Seconds()))),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &streamResponse{}
+	err = decodeHelperResult(ret, res)
+	return res, err
+}
+
+// interruptOnSignal returns a channel closed on the first Ctrl-C.
+// A second one exits right away.
+func interruptOnSignal() <-chan struct{} {
+	interrupt := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		close(interrupt)
+		<-signals
+		os.Exit(130)
+	}()
+	return interrupt
+}
+
+// watchSignals replaces readline's exit signal handling: Ctrl-C while a
+// stream runs interrupts it, any other time it closes the REPL.
+func (s *replSession) watchSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			s.mu.Lock()
+			interrupt := s.interrupt
+			s.interrupt = nil
+			s.mu.Unlock()
+
+			if sig == os.Interrupt && interrupt != nil {
+				interrupt()
+				continue
+			}
+			_ = s.rl.Close()
+		}
+	}()
+}
+
+func (s *replSession) evaluateStreaming(code string) (*evalResult, error) {
+	interrupt := make(chan struct{})
+	s.mu.Lock()
+	s.interrupt = func() { close(interrupt) }
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.interrupt = nil
+		s.mu.Unlock()
+	}()
+
+	return EvaluateStreaming(code, s.format.Depth, func(lines []string) {
+		fmt.Println("[OUT] " + strings.Join(lines, "\n[OUT] "))
+	}, interrupt)
+}
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/Consoleaf/kopl/luahelpers"
+	"github.com/pkg/sftp"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+// Chunks with longer URLs are staged over SFTP in "auto" mode
+const maxReplURLLength = 4096
+
+var replTransportMode string
+
+func AddTransportFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(
+		&replTransportMode,
+		"transport",
+		"auto",
+		"How code is sent to the device: 'url' encodes it into the request URL, "+
+			"'sftp' uploads it to a temporary file first (keeps code out of HTTP logs), "+
+			"'auto' uploads only chunks too large for a URL",
+	)
+}
+
+// replTransport sends a chunk to repl.koplugin and returns the raw response.
+type replTransport interface {
+	Send(code string) ([]byte, error)
+	Close()
+}
+
+var transport replTransport = urlTransport{}
+
+// setupReplTransport picks the transport configured with --transport,
+// once the running repl.koplugin is known to evaluate what it sends.
+func setupReplTransport() error {
+	version, err := Inspector.Get("ui/Repl/version")
+	if err != nil {
+		return fmt.Errorf("while checking the version of repl.koplugin: %w", err)
+	}
+	err = checkReplVersion(strings.TrimSpace(string(version)))
+	if err != nil {
+		return err
+	}
+
+	switch replTransportMode {
+	case "url":
+		transport = urlTransport{}
+	case "sftp":
+		transport = &stagedTransport{}
+	case "auto":
+		transport = &stagedTransport{threshold: maxReplURLLength}
+	default:
+		return fmt.Errorf("invalid transport %q, expected auto, url or sftp", replTransportMode)
+	}
+	return nil
+}
+
+// urlTransport base64-encodes chunks into the URL of a GET request.
+type urlTransport struct{}
+
+func (urlTransport) Send(code string) ([]byte, error) {
+	return Inspector.Get(replURL(code))
+}
+
+func (urlTransport) Close() {}
+
+func replURL(code string) string {
+	return "/ui/Repl/repl/" + base64.StdEncoding.EncodeToString([]byte(code))
+}
+
+// stagedTransport uploads chunks to a temporary file on the device over
+// SFTP and has the Staged helper run them from there. The helper is defined
+// with the first staged chunk, later ones only send the short call.
+type stagedTransport struct {
+	// Chunks with URLs up to this length are sent directly. 0 stages everything.
+	threshold int
+
+	revert func()
+	conn   *ssh.Client
+	client *sftp.Client
+	// defined is set once the Staged helper is defined on the device
+	defined bool
+}
+
+func (t *stagedTransport) Send(code string) ([]byte, error) {
+	if len(replURL(code)) <= t.threshold {
+		return urlTransport{}.Send(code)
+	}
+
+	err := t.connect()
+	if err != nil {
+		return nil, fmt.Errorf("while connecting over SSH to stage the chunk: %w", err)
+	}
+
+	suffix := make([]byte, 8)
+	_, _ = rand.Read(suffix)
+	remotePath := path.Join("/tmp", "kopl-"+hex.EncodeToString(suffix)+".lua")
+
+	f, err := t.client.Create(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write([]byte(code))
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("Staged chunk", "path", remotePath, "bytes", len(code))
+
+	ret, err := t.runStaged(remotePath)
+	if err != nil {
+		_ = t.client.Remove(remotePath)
+	}
+	return ret, err
+}
+
+// runStaged has the Staged helper run the chunk at remotePath, defining
+// the helper if it isn't yet, or is gone because KOReader was restarted.
+func (t *stagedTransport) runStaged(remotePath string) ([]byte, error) {
+	if t.defined {
+		ret, err := urlTransport{}.Send(luahelpers.Staged.Call(remotePath))
+		if err != nil || !isMissingHelper(ret) {
+			return ret, err
+		}
+		logger.Debug("Staging helper is gone, defining it again")
+	}
+
+	ret, err := urlTransport{}.Send(luahelpers.Staged.Define(remotePath))
+	if err == nil {
+		t.defined = true
+	}
+	return ret, err
+}
+
+// isMissingHelper reports whether a raw response says that the called
+// helper isn't installed.
+func isMissingHelper(ret []byte) bool {
+	var response []ReplResponse
+	err := json.Unmarshal(ret, &response)
+	return err == nil && len(response) != 0 && response[0].Return == luahelpers.Missing
+}
+
+func (t *stagedTransport) connect() error {
+	if t.client != nil {
+		return nil
+	}
+
+	revert, err := makeRevertSSHAllowNoPassword()
+	if err != nil {
+		return err
+	}
+	t.revert = revert
+
+	t.conn, err = connectSSH()
+	if err != nil {
+		return err
+	}
+
+	t.client, err = sftp.NewClient(t.conn)
+	return err
+}
+
+func (t *stagedTransport) Close() {
+	if t.client != nil {
+		t.client.Close()
+	}
+	if t.conn != nil {
+		t.conn.Close()
+	}
+	if t.revert != nil {
+		t.revert()
+	}
+}
+
+// installedHelpers are the helpers already defined on the device
+var installedHelpers = map[string]bool{}
+
+// libInstalled is set once luahelpers.Lib is installed on the device
+var libInstalled bool
+
+// EvaluateHelper calls a Lua helper on the device, installing it first
+// if needed.
+func EvaluateHelper(helper luahelpers.Helper, args ...string) (any, []string, error) {
+	if installedHelpers[helper.ID()] {
+		ret, out, err := Evaluate(helper.Call(args...))
+		if err != nil || ret != luahelpers.Missing {
+			return ret, out, err
+		}
+		logger.Debug("Helper is gone, defining it again", "helper", helper.ID())
+		// KOReader was restarted, which took everything else with it
+		clear(installedHelpers)
+		libInstalled = false
+	}
+
+	err := ensureLib()
+	if err != nil {
+		return nil, nil, err
+	}
+	ret, out, err := Evaluate(helper.Define(args...))
+	if err == nil {
+		installedHelpers[helper.ID()] = true
+	}
+	return ret, out, err
+}
+
+// ensureLib installs the module shared by the helpers before the first
+// one is defined. It goes through the transport like any chunk, so it's
+// staged if it's too large for a URL.
+func ensureLib() error {
+	if libInstalled {
+		return nil
+	}
+	_, _, err := Evaluate(luahelpers.DefineLib())
+	if err != nil {
+		return fmt.Errorf("while installing %s: %w", luahelpers.LibModule, err)
+	}
+	libInstalled = true
+	return nil
+}
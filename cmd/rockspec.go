@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Consoleaf/kopl/luasyntax"
+)
+
+// Build types of rockspecs that compile code
+var compilingBuildTypes = []string{"make", "cmake"}
+
+// rockCModules downloads the rockspecs of a rock and its dependencies and
+// returns what they'd compile, so that C rocks are refused before
+// LuaRocks tries building them for the host.
+func rockCModules(luarocks string, rock string, version string) ([]string, error) {
+	return collectRockCModules(luarocks, rock, version, map[string]bool{})
+}
+
+func collectRockCModules(luarocks string, rock string, version string, seen map[string]bool) ([]string, error) {
+	if seen[rock] {
+		return nil, nil
+	}
+	seen[rock] = true
+
+	src, err := downloadRockspec(luarocks, rock, version)
+	if err != nil {
+		return nil, err
+	}
+
+	modules, err := rockspecCModules(src)
+	if err != nil {
+		return nil, fmt.Errorf("while reading the rockspec of %s: %w", rock, err)
+	}
+	for i, module := range modules {
+		modules[i] = rock + ": " + module
+	}
+
+	dependencies, err := luasyntax.ParseAssignedTable(src, "dependencies")
+	if err != nil {
+		return nil, fmt.Errorf("while reading the rockspec of %s: %w", rock, err)
+	}
+	if dependencies == nil {
+		return modules, nil
+	}
+	for _, dependency := range dependencies.Strings() {
+		fields := strings.Fields(dependency)
+		// The interpreter is KOReader's LuaJIT
+		if len(fields) == 0 || fields[0] == "lua" || fields[0] == "luajit" {
+			continue
+		}
+		depModules, err := collectRockCModules(luarocks, fields[0], "", seen)
+		if err != nil {
+			return nil, err
+		}
+		modules = append(modules, depModules...)
+	}
+	return modules, nil
+}
+
+// downloadRockspec returns the rockspec of a rock, of its latest version
+// if version is empty.
+func downloadRockspec(luarocks string, rock string, version string) (string, error) {
+	dir, err := os.MkdirTemp("", "kopl-rockspec-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	args := []string{"download", "--rockspec", "--lua-version", luaVersion, rock}
+	if version != "" {
+		args = append(args, version)
+	}
+	download := exec.Command(luarocks, args...)
+	download.Dir = dir
+	download.Stderr = os.Stderr
+	err = download.Run()
+	if err != nil {
+		return "", fmt.Errorf("while running `luarocks download` for %s: %w", rock, err)
+	}
+
+	specs, err := filepath.Glob(filepath.Join(dir, "*.rockspec"))
+	if err != nil {
+		return "", err
+	}
+	if len(specs) == 0 {
+		return "", fmt.Errorf("`luarocks download` didn't fetch a rockspec for %s", rock)
+	}
+	src, err := os.ReadFile(specs[0])
+	return string(src), err
+}
+
+// rockspecCModules returns the modules a rockspec builds from C sources,
+// or its build type if it runs a build system.
+func rockspecCModules(src string) ([]string, error) {
+	build, err := luasyntax.ParseAssignedTable(src, "build")
+	if err != nil || build == nil {
+		return nil, err
+	}
+
+	buildType := build.String("type")
+	if slices.Contains(compilingBuildTypes, buildType) {
+		return []string{fmt.Sprintf("builds with %s", buildType)}, nil
+	}
+
+	modules := build.Table("modules")
+	if modules == nil {
+		return nil, nil
+	}
+	var cModules []string
+	for _, field := range modules.Fields {
+		name, ok := field.Key.(string)
+		if ok && isCModule(field.Value) {
+			cModules = append(cModules, fmt.Sprintf("module %s is built from C", name))
+		}
+	}
+	return cModules, nil
+}
+
+// isCModule reports whether the value of an entry of a builtin build's
+// modules is built from C: a C file, a list of them or a table of sources.
+func isCModule(value any) bool {
+	isC := func(file string) bool {
+		return strings.HasSuffix(file, ".c")
+	}
+	switch value := value.(type) {
+	case string:
+		return isC(value)
+	case *luasyntax.Table:
+		if slices.ContainsFunc(value.Strings(), isC) || isC(value.String("sources")) {
+			return true
+		}
+		if sources := value.Table("sources"); sources != nil {
+			return slices.ContainsFunc(sources.Strings(), isC)
+		}
+	}
+	return false
+}
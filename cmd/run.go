@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	runCode  string
+	runJSON  bool
+	runDepth int
+)
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+	AddInspectorArgs(runCmd)
+	AddSSHFlags(runCmd)
+	AddTransportFlags(runCmd)
+	AddBridgeFlags(runCmd)
+	AddStreamFlags(runCmd)
+
+	runCmd.Flags().StringVarP(
+		&runCode,
+		"eval",
+		"e",
+		"",
+		"Code to run instead of a file",
+	)
+	runCmd.Flags().BoolVar(
+		&runJSON,
+		"json",
+		false,
+		"Print the result as JSON: {\"ret\": [...], \"out\": [...], \"error\": \"...\"}",
+	)
+	runCmd.Flags().IntVar(
+		&runDepth,
+		"depth",
+		2,
+		"How deep returned tables are serialized",
+	)
+}
+
+var runCmd = &cobra.Command{
+	Use:   "run [file.lua|-]",
+	Short: "Run Lua code inside KOReader",
+	Long: `Run a Lua file (or stdin with "-", or code given with -e) inside KOReader
+and print its output and return values.
+Exits with a non-zero code if the code fails.`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		code, err := readRunCode(args)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = connectRepl()
+		if err != nil {
+			log.Fatal(err)
+		}
+		ok := runImpl(code)
+		transport.Close()
+		if !ok {
+			os.Exit(1)
+		}
+	},
+}
+
+func readRunCode(args []string) (string, error) {
+	if runCode != "" && len(args) != 0 {
+		return "", fmt.Errorf("pass either a file or -e, not both")
+	}
+	if runCode != "" {
+		return runCode, nil
+	}
+	if len(args) == 0 {
+		return "", fmt.Errorf("pass a file or -e <code>")
+	}
+
+	var code []byte
+	var err error
+	if args[0] == "-" {
+		code, err = io.ReadAll(os.Stdin)
+	} else {
+		code, err = os.ReadFile(args[0])
+	}
+	return string(code), err
+}
+
+type runOutput struct {
+	Return []string `json:"ret"`
+	Output []string `json:"out"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// runImpl runs code and prints the results. Returns false if it failed.
+func runImpl(code string) bool {
+	output := runOutput{Return: []string{}, Output: []string{}}
+	// Position or traceback of the error
+	var details string
+
+	var result *evalResult
+	var out []string
+	var err error
+	if streamOutput {
+		result, err = EvaluateStreaming(code, runDepth, func(lines []string) {
+			if runJSON {
+				output.Output = append(output.Output, lines...)
+			} else {
+				fmt.Println(strings.Join(lines, "\n"))
+			}
+		}, interruptOnSignal())
+	} else {
+		result, out, err = EvaluateStructured(code, runDepth)
+	}
+	if out != nil {
+		output.Output = out
+	}
+	switch {
+	case err != nil:
+		output.Error = err.Error()
+	case result.Error != nil:
+		output.Error = result.Error.Error()
+		details = result.Error.details(code)
+	default:
+		format := replFormat{Depth: runDepth, Raw: runJSON}
+		for _, value := range result.Values {
+			output.Return = append(output.Return, format.render(value))
+		}
+	}
+
+	if runJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(output)
+		return output.Error == ""
+	}
+
+	if len(output.Output) > 0 {
+		fmt.Println(strings.Join(output.Output, "\n"))
+	}
+	for _, value := range output.Return {
+		fmt.Println(value)
+	}
+	if output.Error != "" {
+		fmt.Fprintln(os.Stderr, output.Error)
+		if details != "" {
+			fmt.Fprintln(os.Stderr, details)
+		}
+		return false
+	}
+	return true
+}
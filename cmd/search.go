@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	internalerror "github.com/Consoleaf/kopl/internal_error"
+	"github.com/Consoleaf/kopl/koplugin"
+	"github.com/spf13/cobra"
+)
+
+var IndexSource string
+
+func init() {
+	rootCmd.AddCommand(searchCmd)
+	AddIndexFlags(searchCmd)
+}
+
+func AddIndexFlags(command *cobra.Command) {
+	envIndex, exists := os.LookupEnv("KOPL_INDEX")
+	if exists {
+		IndexSource = envIndex
+	}
+
+	command.Flags().StringVar(
+		&IndexSource,
+		"index",
+		IndexSource,
+		"Plugin index: a URL or path of a JSON file. You can also set this in envvar KOPL_INDEX",
+	)
+}
+
+var searchCmd = &cobra.Command{
+	Use:   "search <term>",
+	Short: "Search the plugin index",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		index, err := loadIndex()
+		if err != nil {
+			internalerror.ErrorExit(err)
+		}
+
+		results := index.Search(args[0])
+		if len(results) == 0 {
+			internalerror.ErrorExitf("No plugins matching %q\n", args[0])
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tVERSION\tREPO\tDESCRIPTION")
+		for _, entry := range results {
+			description, _, _ := strings.Cut(entry.Description, "\n")
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.Name, entry.Version, entry.Repo, description)
+		}
+		w.Flush()
+	},
+}
+
+func loadIndex() (*koplugin.Index, error) {
+	if IndexSource == "" {
+		return nil, fmt.Errorf("no plugin index configured. Pass --index or set KOPL_INDEX")
+	}
+	return koplugin.LoadIndex(IndexSource)
+}
+
+// resolveIndexName turns a plugin name from the index into its repository
+// and the version the index lists. Anything that already looks like
+// a repository is returned as is, with no version.
+func resolveIndexName(name string) (repo string, version string, err error) {
+	if strings.Contains(name, "/") {
+		return name, "", nil
+	}
+	if IndexSource == "" {
+		return "", "", fmt.Errorf(
+			"%q isn't a repository like owner/name.koplugin, and no plugin index is configured to look it up in. "+
+				"Pass --index or set KOPL_INDEX",
+			name,
+		)
+	}
+
+	index, err := loadIndex()
+	if err != nil {
+		return "", "", err
+	}
+	entry, ok := index.Lookup(name)
+	if !ok {
+		return "", "", fmt.Errorf("plugin %q not found in the index", name)
+	}
+	logger.Info(fmt.Sprintf("Found %s %s in the index: %s", entry.Name, entry.Version, entry.Repo))
+	return entry.Repo, entry.Version, nil
+}
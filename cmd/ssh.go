@@ -15,6 +15,10 @@ var (
 	SSHUser         string
 	SSHPassword     string
 	SSHIdentityPath string
+
+	// sshOverInspector is set when the SSH server was started by HTTP Inspector
+	// and thus goes down together with KOReader.
+	sshOverInspector bool
 )
 
 func AddSSHFlags(cmd *cobra.Command) {
@@ -61,6 +65,7 @@ func connectSSH() (*ssh.Client, error) {
 		if err != nil {
 			return nil, err
 		}
+		sshOverInspector = true
 
 		err = Inspector.SSHSetAllowNoPassword(true)
 		if err != nil {
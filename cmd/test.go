@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Consoleaf/kopl/coverage"
+	internalerror "github.com/Consoleaf/kopl/internal_error"
+	"github.com/Consoleaf/kopl/koplugin"
+	"github.com/Consoleaf/kopl/luastubs"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(testCmd)
+	AddTestDeviceFlags(testCmd)
+	AddCoverageFlags(testCmd)
+}
+
+var testCmd = &cobra.Command{
+	Use:   "test [spec...] [-- busted args...]",
+	Short: "Run the plugin's specs with busted",
+	Long: `Run the specs in spec/ (or the given ones) with busted, which is installed
+with luarocks if it isn't in PATH.
+
+Specs require the plugin's modules like KOReader does. UIManager, InfoMessage,
+Dispatcher, gettext, logger and the settings are replaced by stubs recording
+what the plugin does with them. Other modules are loaded from the koreader
+submodule's frontend.
+
+Arguments after -- are passed to busted, e.g. kopl test -- --filter=sync
+
+With --on-device, spec/ is uploaded into the plugin deployed on the device
+and the specs run inside KOReader, where nothing is stubbed. A runner with
+busted's describe/it, hooks and common assertions is used there.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		specs, bustedArgs := args, []string(nil)
+		if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+			specs, bustedArgs = args[:dash], args[dash:]
+		}
+
+		if testOnDevice {
+			if len(bustedArgs) > 0 {
+				internalerror.ErrorExitf("Arguments after -- are for busted, which doesn't run with --on-device\n")
+			}
+			results, hits, err := testOnDeviceImpl(specs)
+			if err != nil {
+				internalerror.ErrorExit(err)
+			}
+			passed, err := writeTestReport(results)
+			if err != nil {
+				internalerror.ErrorExit(err)
+			}
+			if testCoverage {
+				err = saveCoverage("device", hits)
+				if err == nil {
+					err = reportCoverage("device")
+				}
+				if err != nil {
+					internalerror.ErrorExit(err)
+				}
+			}
+			if !passed {
+				os.Exit(1)
+			}
+			return
+		}
+		if testJUnit != "" {
+			internalerror.ErrorExitf("--junit needs --on-device. Locally, use busted's: kopl test -- -o junit\n")
+		}
+
+		err := testImpl(specs, bustedArgs)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// busted reported the failures itself
+			os.Exit(exitErr.ExitCode())
+		}
+		if err != nil {
+			internalerror.ErrorExit(err)
+		}
+	},
+}
+
+func testImpl(specs []string, bustedArgs []string) error {
+	err := ensureRock("busted", "busted")
+	if err != nil {
+		return err
+	}
+
+	stubsDir, err := os.MkdirTemp("", "kopl-stubs-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stubsDir)
+	err = luastubs.Extract(stubsDir)
+	if err != nil {
+		return err
+	}
+
+	lpath, err := testPackagePath(".", filepath.Join(stubsDir, luastubs.ModulesDir))
+	if err != nil {
+		return err
+	}
+	args := []string{
+		"--lpath", lpath,
+		"--helper", filepath.Join(stubsDir, luastubs.Helper),
+	}
+	args = append(args, bustedArgs...)
+	args = append(args, specs...)
+
+	cmd := exec.Command("busted", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if !testCoverage {
+		return cmd.Run()
+	}
+
+	statsPath, err := filepath.Abs(coverageStatsPath("local"))
+	if err != nil {
+		return err
+	}
+	// Not to report a previous run if busted doesn't get to write one
+	err = os.Remove(statsPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(statsPath), 0o755)
+	if err != nil {
+		return err
+	}
+	cmd.Env = append(os.Environ(), "KOPL_COVERAGE="+statsPath)
+
+	testErr := cmd.Run()
+	err = saveLocalCoverage(statsPath)
+	if err == nil {
+		err = reportCoverage("local")
+	}
+	if err != nil && testErr != nil {
+		// Failing tests explain the missing coverage better
+		logger.Error(err.Error())
+		return testErr
+	}
+	return errors.Join(testErr, err)
+}
+
+// saveLocalCoverage makes the paths busted's helper wrote relative to
+// the plugin.
+func saveLocalCoverage(statsPath string) error {
+	hits, err := coverage.ReadHits(statsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("busted didn't finish, so no coverage was collected")
+	}
+	if err != nil {
+		return err
+	}
+	pluginDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	return saveCoverage("local", hits.Relative(pluginDir))
+}
+
+// testPackagePath returns where specs of the plugin in pluginDir look for
+// modules: the plugin's own first, like in KOReader, then its vendored ones,
+// the stubs and KOReader's frontend.
+func testPackagePath(pluginDir string, stubsDir string) (string, error) {
+	config, err := koplugin.ReadConfig(pluginDir)
+	if err != nil {
+		return "", err
+	}
+	dirs := []string{pluginDir, filepath.Join(pluginDir, config.Vendor()), stubsDir}
+	if koreader := koplugin.FindUp(pluginDir, "koreader"); koreader != "" {
+		dirs = append(dirs, filepath.Join(koreader, "frontend"))
+	} else {
+		logger.Warn("koreader submodule not found. Only the stubbed KOReader modules can be required")
+	}
+
+	var patterns []string
+	for _, dir := range dirs {
+		patterns = append(
+			patterns,
+			filepath.Join(dir, "?.lua"),
+			filepath.Join(dir, "?", "init.lua"),
+		)
+	}
+	return strings.Join(patterns, ";"), nil
+}
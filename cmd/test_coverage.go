@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Consoleaf/kopl/coverage"
+	"github.com/spf13/cobra"
+)
+
+// coverageStatsDir keeps the lines that ran in the last local and
+// on-device runs, which reports merge
+var coverageStatsDir = filepath.Join(".kopl", "coverage")
+
+// Under .kopl/, so that reports aren't deployed with the plugin
+var defaultCoverageDir = filepath.Join(".kopl", "coverage", "report")
+
+var (
+	testCoverage      bool
+	coverageDir       string
+	coverageThreshold float64
+)
+
+func AddCoverageFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(
+		&testCoverage,
+		"coverage",
+		false,
+		"Measure which lines of the plugin the specs run and write LCOV and HTML reports",
+	)
+	cmd.Flags().StringVar(
+		&coverageDir,
+		"coverage-dir",
+		defaultCoverageDir,
+		"Where to write lcov.info and index.html",
+	)
+	cmd.Flags().Float64Var(
+		&coverageThreshold,
+		"coverage-threshold",
+		0,
+		"Fail if less than this percentage of lines ran",
+	)
+}
+
+// coverageStatsPath is where the lines that ran in the last run of a kind,
+// local or device, are kept.
+func coverageStatsPath(run string) string {
+	return filepath.Join(coverageStatsDir, run+".json")
+}
+
+// saveCoverage replaces the lines that ran in the last run of a kind.
+func saveCoverage(run string, hits coverage.Hits) error {
+	err := os.MkdirAll(coverageStatsDir, 0o755)
+	if err != nil {
+		return err
+	}
+	return coverage.WriteHits(coverageStatsPath(run), hits)
+}
+
+// reportCoverage writes the reports of run merged with the last run of the
+// other kind, and fails if coverage is below the threshold. The other run is
+// left out if the plugin changed since, as its lines may not match anymore.
+func reportCoverage(run string) error {
+	stats, err := filepath.Glob(filepath.Join(coverageStatsDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	modified, err := coverage.LastModified(".")
+	if err != nil {
+		return err
+	}
+
+	hits := coverage.Hits{}
+	var runs []string
+	for _, path := range stats {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		if name != run {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			if info.ModTime().Before(modified) {
+				logger.Warn(fmt.Sprintf("Leaving out the last %s run, the plugin changed since", name))
+				continue
+			}
+		}
+
+		runHits, err := coverage.ReadHits(path)
+		if err != nil {
+			return fmt.Errorf("while reading %s: %w", path, err)
+		}
+		hits.Merge(runHits)
+		runs = append(runs, name)
+	}
+
+	files, err := coverage.Analyze(".", hits)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(coverageDir, 0o755)
+	if err != nil {
+		return err
+	}
+	err = writeFile(filepath.Join(coverageDir, "lcov.info"), func(f *os.File) error {
+		return coverage.WriteLCOV(f, files)
+	})
+	if err != nil {
+		return err
+	}
+	htmlPath := filepath.Join(coverageDir, "index.html")
+	err = writeFile(htmlPath, func(f *os.File) error {
+		return coverage.WriteHTML(f, files)
+	})
+	if err != nil {
+		return err
+	}
+
+	covered, total := coverage.Total(files)
+	percent := coverage.Percent(covered, total)
+	fmt.Printf(
+		"Coverage: %.1f%% (%d of %d lines, %s runs). Report: %s\n",
+		percent,
+		covered,
+		total,
+		strings.Join(runs, " and "),
+		htmlPath,
+	)
+	if percent < coverageThreshold {
+		return fmt.Errorf("coverage of %.1f%% is below the threshold of %.1f%%", percent, coverageThreshold)
+	}
+	return nil
+}
+
+func writeFile(path string, write func(*os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Consoleaf/kopl/coverage"
+	"github.com/Consoleaf/kopl/luahelpers"
+	"github.com/Consoleaf/kopl/testreport"
+	"github.com/pkg/sftp"
+	"github.com/spf13/cobra"
+)
+
+// specDir is where specs are, locally and on the device
+const specDir = "spec"
+
+var (
+	testOnDevice bool
+	testJUnit    string
+)
+
+func AddTestDeviceFlags(cmd *cobra.Command) {
+	AddInspectorArgs(cmd)
+	AddSSHFlags(cmd)
+	AddTransportFlags(cmd)
+	// Includes --deploy-path
+	AddBridgeFlags(cmd)
+
+	cmd.Flags().BoolVar(
+		&testOnDevice,
+		"on-device",
+		false,
+		"Run the specs inside KOReader on the device, against the deployed plugin",
+	)
+	cmd.Flags().StringVar(
+		&testJUnit,
+		"junit",
+		"",
+		"With --on-device, also write the results as JUnit XML to this file",
+	)
+}
+
+// testOnDeviceImpl uploads the specs into the deployed plugin and runs
+// them inside KOReader.
+// Returns the lines of the plugin that ran with --coverage.
+func testOnDeviceImpl(specs []string) ([]testreport.Result, coverage.Hits, error) {
+	pluginDir, err := os.Getwd()
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := findSpecs(specs)
+	if err != nil {
+		return nil, nil, err
+	}
+	remoteDir := path.Join(deployPath, filepath.Base(pluginDir))
+
+	err = connectRepl()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer transport.Close()
+
+	err = uploadSpecs(remoteDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger.Info(fmt.Sprintf("Running %d spec files on the device...", len(files)))
+	collect := ""
+	if testCoverage {
+		collect = "1"
+	}
+	ret, out, err := EvaluateHelper(luahelpers.Spec, remoteDir, strings.Join(files, "\n"), collect)
+	for _, line := range out {
+		fmt.Println("[OUT] " + line)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var report struct {
+		Tests    []testreport.Result `json:"tests"`
+		Coverage coverage.Hits       `json:"coverage"`
+	}
+	err = decodeHelperResult(ret, &report)
+	if err != nil {
+		return nil, nil, err
+	}
+	return report.Tests, report.Coverage, nil
+}
+
+// findSpecs returns the spec files in paths, relative to the plugin and
+// with forward slashes. Specs are uploaded with spec/, so they must be in it.
+func findSpecs(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		paths = []string{specDir}
+	}
+
+	var files []string
+	for _, root := range paths {
+		rel := filepath.ToSlash(filepath.Clean(root))
+		if rel != specDir && !strings.HasPrefix(rel, specDir+"/") {
+			return nil, fmt.Errorf("%s isn't in %s/. Only specs there are uploaded to the device", root, specDir)
+		}
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// Files given explicitly are run whatever their name
+			if p == root && !d.IsDir() {
+				files = append(files, filepath.ToSlash(p))
+			} else if !d.IsDir() && strings.HasSuffix(d.Name(), "_spec.lua") {
+				files = append(files, filepath.ToSlash(p))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no *_spec.lua files found in %s", strings.Join(paths, ", "))
+	}
+	slices.Sort(files)
+	return slices.Compact(files), nil
+}
+
+// uploadSpecs replaces spec/ in the plugin's directory on the device with
+// the local one.
+func uploadSpecs(remoteDir string) error {
+	revert, err := makeRevertSSHAllowNoPassword()
+	if err != nil {
+		return err
+	}
+	defer revert()
+
+	conn, err := connectSSH()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	_, err = client.Stat(remoteDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s isn't on the device. Deploy the plugin first with `kopl deploy`", remoteDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	remoteSpecDir := path.Join(remoteDir, specDir)
+	err = removeRemoteIfExists(client, remoteSpecDir)
+	if err != nil {
+		return err
+	}
+	logger.Info(fmt.Sprintf("Uploading specs to '%s'...", remoteSpecDir))
+	return uploadTree(specDir, remoteSpecDir, client)
+}
+
+// writeTestReport prints the results and writes them to --junit.
+// Returns false if any test didn't pass.
+func writeTestReport(results []testreport.Result) (bool, error) {
+	err := testreport.WriteText(os.Stdout, results)
+	if err != nil {
+		return false, err
+	}
+
+	if testJUnit != "" {
+		f, err := os.Create(testJUnit)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		err = testreport.WriteJUnit(f, results)
+		if err != nil {
+			return false, err
+		}
+	}
+	return testreport.Passed(results), nil
+}
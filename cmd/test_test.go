@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Consoleaf/kopl/koplugin"
+)
+
+func TestTestPackagePath(t *testing.T) {
+	repo := t.TempDir()
+	pluginDir := filepath.Join(repo, "hello.koplugin")
+	err := os.MkdirAll(filepath.Join(repo, "koreader", "frontend"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(pluginDir, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := koplugin.Config{VendorDir: "lib"}
+	err = config.Write(pluginDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := testPackagePath(pluginDir, "/stubs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, dir := range []string{
+		pluginDir,
+		filepath.Join(pluginDir, "lib"),
+		"/stubs",
+		filepath.Join(repo, "koreader", "frontend"),
+	} {
+		want = append(want, filepath.Join(dir, "?.lua"), filepath.Join(dir, "?", "init.lua"))
+	}
+	if got != strings.Join(want, ";") {
+		t.Errorf("got\n%s\nwant\n%s", got, strings.Join(want, ";"))
+	}
+}
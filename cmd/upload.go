@@ -19,8 +19,11 @@ func UploadDirectory(localPath string, remoteParentDir string, client *sftp.Clie
 		remoteParentDir,
 	))
 
-	remoteParentDir = path.Join(remoteParentDir, path.Base(localPath))
+	return uploadTree(localPath, path.Join(remoteParentDir, path.Base(localPath)), client)
+}
 
+// uploadTree uploads the contents of localPath into remoteDir.
+func uploadTree(localPath string, remoteDir string, client *sftp.Client) error {
 	err := filepath.WalkDir(
 		localPath,
 		func(path string, d fs.DirEntry, err error) error {
@@ -40,7 +43,7 @@ func UploadDirectory(localPath string, remoteParentDir string, client *sftp.Clie
 			}
 
 			// Convert to Unix-style path for SFTP
-			remotePath := filepath.ToSlash(filepath.Join(remoteParentDir, relPath))
+			remotePath := filepath.ToSlash(filepath.Join(remoteDir, relPath))
 
 			if d.IsDir() {
 				// Create directory on remote if it doesn't exist
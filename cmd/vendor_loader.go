@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Consoleaf/kopl/luatemplates"
+)
+
+const pluginMainFile = "main.lua"
+
+// vendorLoaderBlock is the part of main.lua kopl manages, which lets
+// modules vendored into vendorDir be required.
+func vendorLoaderBlock(vendorDir string) (string, error) {
+	var buf bytes.Buffer
+	err := luatemplates.VendorLoaderTemplate.Execute(&buf, luatemplates.TemplateArgsForInit{VendorDir: vendorDir})
+	return buf.String(), err
+}
+
+// mergeVendorLoader puts block into the source of main.lua. An outdated
+// block is replaced in place, otherwise it goes after the module's doc
+// comment, before anything is required.
+func mergeVendorLoader(src string, block string) string {
+	if merged, ok := replaceManagedBlock(src, block); ok {
+		return merged
+	}
+	if !strings.HasPrefix(src, "--[[") {
+		return block + "\n" + src
+	}
+	end := strings.Index(src, "]]")
+	if end < 0 {
+		return block + "\n" + src
+	}
+	end += len("]]")
+	// The rest of the comment's last line
+	if newline := strings.IndexByte(src[end:], '\n'); newline >= 0 {
+		end += newline + 1
+	} else {
+		end = len(src)
+	}
+	return src[:end] + "\n" + block + src[end:]
+}
+
+// readVendorLoader returns main.lua of the plugin in pluginDir and what it
+// is with an up to date vendor loader for vendorDir.
+func readVendorLoader(pluginDir string, vendorDir string) (string, string, error) {
+	src, err := os.ReadFile(filepath.Join(pluginDir, pluginMainFile))
+	if err != nil {
+		return "", "", err
+	}
+	block, err := vendorLoaderBlock(vendorDir)
+	if err != nil {
+		return "", "", err
+	}
+	return string(src), mergeVendorLoader(string(src), block), nil
+}
+
+// ensureVendorLoader adds the vendor loader to main.lua of the plugin in
+// pluginDir, or updates it to require modules from vendorDir.
+func ensureVendorLoader(pluginDir string, vendorDir string) error {
+	src, merged, err := readVendorLoader(pluginDir, vendorDir)
+	if err != nil {
+		return err
+	}
+	if merged == src {
+		return nil
+	}
+	err = os.WriteFile(filepath.Join(pluginDir, pluginMainFile), []byte(merged), 0o644)
+	if err != nil {
+		return err
+	}
+	logger.Info(fmt.Sprintf("Set up %s to require modules from '%s'", pluginMainFile, vendorDir))
+	return nil
+}
+
+// hasVendorLoader reports whether main.lua of the plugin in pluginDir has
+// an up to date vendor loader for vendorDir.
+func hasVendorLoader(pluginDir string, vendorDir string) bool {
+	src, merged, err := readVendorLoader(pluginDir, vendorDir)
+	return err == nil && merged == src
+}
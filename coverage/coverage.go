@@ -0,0 +1,286 @@
+// Package coverage turns the lines a plugin's tests ran into reports.
+//
+// Hits are collected by a line hook inside Lua, locally under busted and on
+// the device, and can be merged across runs.
+package coverage
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Consoleaf/kopl/luasyntax"
+)
+
+// Hits is how many times each line ran, by file.
+type Hits map[string]map[int]int
+
+// ReadHits reads hits saved by WriteHits or the Lua collectors.
+func ReadHits(path string) (Hits, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	hits := Hits{}
+	err = json.Unmarshal(data, &hits)
+	return hits, err
+}
+
+// WriteHits saves hits as JSON.
+func WriteHits(path string, hits Hits) error {
+	data, err := json.Marshal(hits)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Merge adds the hits of other.
+func (h Hits) Merge(other Hits) {
+	for file, lines := range other {
+		if h[file] == nil {
+			h[file] = map[int]int{}
+		}
+		for line, count := range lines {
+			h[file][line] += count
+		}
+	}
+}
+
+// Relative returns the hits of files in root with paths relative to it.
+// Relative paths are taken as relative to root already.
+func (h Hits) Relative(root string) Hits {
+	res := Hits{}
+	for file, lines := range h {
+		rel := filepath.Clean(file)
+		if filepath.IsAbs(rel) {
+			var err error
+			rel, err = filepath.Rel(root, rel)
+			if err != nil {
+				continue
+			}
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		res.Merge(Hits{rel: lines})
+	}
+	return res
+}
+
+// Included reports whether a file of a plugin is covered: the plugin's own
+// code, not its specs, the koreader submodule or vendored rocks.
+func Included(file string) bool {
+	if filepath.Ext(file) != ".lua" {
+		return false
+	}
+	dirs := strings.Split(file, "/")
+	for _, dir := range dirs[:len(dirs)-1] {
+		if isExcludedDir(dir) {
+			return false
+		}
+	}
+	return true
+}
+
+func isExcludedDir(name string) bool {
+	return strings.HasPrefix(name, ".") || name == "koreader" || name == "vendor" || name == "spec"
+}
+
+// File is the coverage of a source file.
+type File struct {
+	// Path is relative to the plugin
+	Path  string
+	Lines []Line
+}
+
+type Line struct {
+	Text string
+	// Executable lines are the ones expected to run
+	Executable bool
+	Hits       int
+}
+
+// Counts returns how many executable lines ran and how many there are.
+func (f File) Counts() (covered int, total int) {
+	for _, line := range f.Lines {
+		if !line.Executable {
+			continue
+		}
+		total++
+		if line.Hits > 0 {
+			covered++
+		}
+	}
+	return covered, total
+}
+
+// Analyze returns the coverage of the included files in dir, including
+// the ones no test loaded.
+func Analyze(dir string, hits Hits) ([]File, error) {
+	var files []File
+	err := walkIncluded(dir, func(path string, rel string, _ fs.DirEntry) error {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, analyzeFile(rel, string(src), hits[rel]))
+		return nil
+	})
+	return files, err
+}
+
+// LastModified returns when an included file in dir last changed.
+// Hits collected before then may not match the code anymore.
+func LastModified(dir string) (time.Time, error) {
+	var last time.Time
+	err := walkIncluded(dir, func(_ string, _ string, d fs.DirEntry) error {
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+		return nil
+	})
+	return last, err
+}
+
+// walkIncluded calls fn with the path of every included file in dir,
+// and the path relative to dir with slashes.
+func walkIncluded(dir string, fn func(path string, rel string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel != "." && isExcludedDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !Included(rel) {
+			return nil
+		}
+		return fn(path, rel, d)
+	})
+}
+
+func analyzeFile(path string, src string, hits map[int]int) File {
+	texts := strings.Split(strings.TrimSuffix(src, "\n"), "\n")
+	executable := executableLines(src)
+
+	file := File{Path: path}
+	for i, text := range texts {
+		number := i + 1
+		file.Lines = append(file.Lines, Line{
+			Text:       strings.TrimSuffix(text, "\r"),
+			Executable: executable[number] || hits[number] > 0,
+			Hits:       hits[number],
+		})
+	}
+	return file
+}
+
+// Total returns how many executable lines of the files ran and how many
+// there are.
+func Total(files []File) (covered int, total int) {
+	for _, file := range files {
+		c, t := file.Counts()
+		covered += c
+		total += t
+	}
+	return covered, total
+}
+
+// Percent is the share of lines that ran. No lines at all count as covered.
+func Percent(covered int, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return float64(covered) * 100 / float64(total)
+}
+
+// executableLines returns the lines where statements start, which the line
+// hook fires for if they run. Lines in the middle of an expression, like
+// fields of a table constructor, may run too but aren't expected to.
+func executableLines(src string) map[int]bool {
+	lines := map[int]bool{}
+	tokens, err := luasyntax.Tokenize(src)
+	if err != nil {
+		// Lines that ran are still reported
+		return lines
+	}
+
+	// Function bodies are statements even inside brackets, like callbacks
+	type context struct {
+		brackets int
+		// blocks is the block depth the function's `end` returns to
+		blocks int
+		// header is the name and parameters of the function
+		header bool
+	}
+	contexts := []context{{}}
+	blocks := 0
+	for _, token := range tokens {
+		current := &contexts[len(contexts)-1]
+		if current.header {
+			switch {
+			case token.Is(luasyntax.Symbol, "("):
+				current.brackets++
+			case token.Is(luasyntax.Symbol, ")"):
+				current.brackets--
+				current.header = current.brackets > 0
+			}
+			continue
+		}
+		switch {
+		case token.Is(luasyntax.Symbol, "("), token.Is(luasyntax.Symbol, "{"), token.Is(luasyntax.Symbol, "["):
+			if current.brackets == 0 {
+				lines[token.Line] = true
+			}
+			current.brackets++
+			continue
+		case token.Is(luasyntax.Symbol, ")"), token.Is(luasyntax.Symbol, "}"), token.Is(luasyntax.Symbol, "]"):
+			current.brackets--
+			continue
+		case token.Is(luasyntax.Keyword, "function"):
+			if current.brackets == 0 {
+				lines[token.Line] = true
+			}
+			contexts = append(contexts, context{blocks: blocks, header: true})
+			blocks++
+			continue
+		case token.Is(luasyntax.Keyword, "do"), token.Is(luasyntax.Keyword, "then"):
+			blocks++
+			continue
+		case token.Is(luasyntax.Keyword, "repeat"):
+			// LuaJIT starts the loop with an instruction on its line
+			blocks++
+		case token.Is(luasyntax.Keyword, "end"):
+			blocks--
+			if len(contexts) > 1 && blocks == current.blocks {
+				contexts = contexts[:len(contexts)-1]
+			}
+			continue
+		case token.Is(luasyntax.Keyword, "until"), token.Is(luasyntax.Keyword, "elseif"):
+			blocks--
+		case token.Is(luasyntax.Keyword, "else"), token.Kind == luasyntax.EOF:
+			continue
+		}
+		if current.brackets == 0 {
+			lines[token.Line] = true
+		}
+	}
+	return lines
+}
@@ -0,0 +1,157 @@
+package coverage
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExecutableLines(t *testing.T) {
+	tests := []struct {
+		name string
+		src  []string
+		want []int
+	}{
+		{
+			"statements",
+			[]string{
+				"local a = 1",
+				"-- comment",
+				"",
+				"print(a)",
+			},
+			[]int{1, 4},
+		},
+		{
+			"table fields and call arguments",
+			[]string{
+				"local t = {",
+				"    a = 1,",
+				"    b = f(",
+				"        2",
+				"    ),",
+				"}",
+				"print(",
+				"    t.a",
+				")",
+			},
+			[]int{1, 7},
+		},
+		{
+			"function parameters",
+			[]string{
+				"local function f(a,",
+				"    b)",
+				"    return a + b",
+				"end",
+			},
+			// `end` only runs if nothing is returned before
+			[]int{1, 3},
+		},
+		{
+			"nested functions in call arguments",
+			[]string{
+				"UIManager:scheduleIn(1, function()",
+				"    local x = {",
+				"        y = 1,",
+				"    }",
+				"    list:each(function(item)",
+				"        print(item)",
+				"    end)",
+				"end)",
+				"print(2)",
+			},
+			[]int{1, 2, 5, 6, 9},
+		},
+		{
+			"function in a table constructor",
+			[]string{
+				"local M = {",
+				"    f = function()",
+				"        return 1",
+				"    end,",
+				"    g = 2,",
+				"}",
+			},
+			[]int{1, 3},
+		},
+		{
+			"repeat until",
+			[]string{
+				"repeat",
+				"    i = i + 1",
+				"until i > 10",
+				"print(i)",
+			},
+			[]int{1, 2, 3, 4},
+		},
+		{
+			"repeat with a nested function",
+			[]string{
+				"repeat",
+				"    local f = function() return 1 end",
+				"until f()",
+				"done()",
+			},
+			[]int{1, 2, 3, 4},
+		},
+		{
+			"elseif and else",
+			[]string{
+				"if a then",
+				"    x = 1",
+				"elseif b then",
+				"    x = 2",
+				"else",
+				"    x = 3",
+				"end",
+				"print(x)",
+			},
+			[]int{1, 2, 3, 4, 6, 8},
+		},
+		{
+			"elseif inside a function",
+			[]string{
+				"local function f(a)",
+				"    if a == 1 then",
+				"        return 1",
+				"    elseif a == 2 then",
+				"        return g(function()",
+				"            return 2",
+				"        end)",
+				"    end",
+				"end",
+				"f(1)",
+			},
+			[]int{1, 2, 3, 4, 5, 6, 10},
+		},
+		{
+			"loops",
+			[]string{
+				"for i = 1, 3 do",
+				"    print(i)",
+				"end",
+				"while x do",
+				"    x = nil",
+				"end",
+			},
+			[]int{1, 2, 4, 5},
+		},
+		{
+			"syntax errors report nothing",
+			[]string{"local s = \"unfinished"},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		lines := executableLines(strings.Join(test.src, "\n") + "\n")
+		var got []int
+		for line := range lines {
+			got = append(got, line)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: got lines %v, want %v", test.name, got, test.want)
+		}
+	}
+}
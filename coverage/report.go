@@ -0,0 +1,89 @@
+package coverage
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+)
+
+// WriteLCOV writes the coverage in the LCOV tracefile format, which CI
+// services and editors read.
+func WriteLCOV(w io.Writer, files []File) error {
+	for _, file := range files {
+		_, err := fmt.Fprintf(w, "SF:%s\n", file.Path)
+		if err != nil {
+			return err
+		}
+		for i, line := range file.Lines {
+			if !line.Executable {
+				continue
+			}
+			_, err = fmt.Fprintf(w, "DA:%d,%d\n", i+1, line.Hits)
+			if err != nil {
+				return err
+			}
+		}
+		covered, total := file.Counts()
+		_, err = fmt.Fprintf(w, "LH:%d\nLF:%d\nend_of_record\n", covered, total)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var htmlReport = template.Must(template.New("coverage").Funcs(template.FuncMap{
+	"counts": func(file File) string {
+		covered, total := file.Counts()
+		return fmt.Sprintf("%d / %d", covered, total)
+	},
+	"percent": func(file File) string {
+		return fmt.Sprintf("%.1f%%", Percent(file.Counts()))
+	},
+	"total": func(files []File) string {
+		covered, total := Total(files)
+		return fmt.Sprintf("%.1f%% (%d / %d lines)", Percent(covered, total), covered, total)
+	},
+	"inc": func(i int) int {
+		return i + 1
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Coverage</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 1em; text-align: left; }
+.lines td { padding: 0 0.5em; font-family: monospace; white-space: pre; }
+.hit { background: #dfd; }
+.missed { background: #fdd; }
+.number, .count { color: #888; text-align: right; }
+</style>
+</head>
+<body>
+<h1>Coverage: {{ total . }}</h1>
+<table>
+<tr><th>File</th><th>Lines</th><th>Covered</th></tr>
+{{- range $i, $file := . }}
+<tr><td><a href="#file-{{ $i }}">{{ $file.Path }}</a></td><td>{{ counts $file }}</td><td>{{ percent $file }}</td></tr>
+{{- end }}
+</table>
+{{- range $i, $file := . }}
+<h2 id="file-{{ $i }}">{{ $file.Path }} <small>{{ percent $file }}</small></h2>
+<table class="lines">
+{{- range $n, $line := $file.Lines }}
+<tr{{ if $line.Executable }} class="{{ if $line.Hits }}hit{{ else }}missed{{ end }}"{{ end }}><td class="number">{{ inc $n }}</td><td class="count">{{ if $line.Executable }}{{ $line.Hits }}{{ end }}</td><td>{{ $line.Text }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+</body>
+</html>
+`))
+
+// WriteHTML writes a page with the coverage of every file and their
+// sources, with the lines that ran and didn't highlighted.
+func WriteHTML(w io.Writer, files []File) error {
+	return htmlReport.Execute(w, files)
+}
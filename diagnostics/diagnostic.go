@@ -0,0 +1,55 @@
+// Package diagnostics is the model for findings of `kopl check`, whichever
+// tool they come from, and writes them in formats CI systems understand.
+package diagnostics
+
+import (
+	"cmp"
+	"slices"
+)
+
+type Severity string
+
+const (
+	Error   Severity = "error"
+	Warning Severity = "warning"
+	Info    Severity = "info"
+)
+
+// Diagnostic is a single finding.
+type Diagnostic struct {
+	// Tool that reported it, e.g. "luacheck"
+	Tool string `json:"tool"`
+	// Code identifies the kind of finding within the tool, e.g. "W211"
+	Code     string   `json:"code"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	// File is slash-separated and relative to the checked directory
+	File string `json:"file"`
+	// Line and Column are 1-based, 0 if the finding is about the whole file.
+	// EndColumn is inclusive.
+	Line      int `json:"line,omitempty"`
+	Column    int `json:"column,omitempty"`
+	EndColumn int `json:"endColumn,omitempty"`
+}
+
+// Sort orders diagnostics by file and position.
+func Sort(diags []Diagnostic) {
+	slices.SortStableFunc(diags, func(a, b Diagnostic) int {
+		return cmp.Or(
+			cmp.Compare(a.File, b.File),
+			cmp.Compare(a.Line, b.Line),
+			cmp.Compare(a.Column, b.Column),
+		)
+	})
+}
+
+// Count returns the number of diagnostics with the given severity.
+func Count(diags []Diagnostic, severity Severity) int {
+	n := 0
+	for _, diag := range diags {
+		if diag.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
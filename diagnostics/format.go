@@ -0,0 +1,181 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Formats maps the names of output formats to their writers
+var Formats = map[string]func(w io.Writer, diags []Diagnostic) error{
+	"text":   WriteText,
+	"json":   WriteJSON,
+	"sarif":  WriteSARIF,
+	"junit":  WriteJUnit,
+	"github": WriteGitHub,
+}
+
+// FormatNames lists the output formats in the order they are documented
+var FormatNames = []string{"text", "json", "sarif", "junit", "github"}
+
+// WriteText writes diagnostics like compilers do, followed by a summary.
+func WriteText(w io.Writer, diags []Diagnostic) error {
+	for _, diag := range diags {
+		_, err := fmt.Fprintf(w, "%s: %s (%s) %s\n", location(diag), diag.Severity, diag.Code, diag.Message)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(
+		w,
+		"Total: %d errors, %d warnings\n",
+		Count(diags, Error),
+		Count(diags, Warning),
+	)
+	return err
+}
+
+func location(diag Diagnostic) string {
+	switch {
+	case diag.Line == 0:
+		return diag.File
+	case diag.Column == 0:
+		return fmt.Sprintf("%s:%d", diag.File, diag.Line)
+	}
+	return fmt.Sprintf("%s:%d:%d", diag.File, diag.Line, diag.Column)
+}
+
+// WriteJSON writes diagnostics as a JSON array.
+func WriteJSON(w io.Writer, diags []Diagnostic) error {
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(diags)
+}
+
+// WriteGitHub writes diagnostics as GitHub Actions workflow commands,
+// which show up as annotations on pull requests.
+func WriteGitHub(w io.Writer, diags []Diagnostic) error {
+	for _, diag := range diags {
+		command := "warning"
+		switch diag.Severity {
+		case Error:
+			command = "error"
+		case Info:
+			command = "notice"
+		}
+
+		properties := []string{"file=" + escapeGitHubProperty(diag.File)}
+		if diag.Line > 0 {
+			properties = append(properties, fmt.Sprintf("line=%d", diag.Line))
+		}
+		if diag.Column > 0 {
+			properties = append(properties, fmt.Sprintf("col=%d", diag.Column))
+		}
+		if diag.EndColumn > 0 {
+			properties = append(properties, fmt.Sprintf("endColumn=%d", diag.EndColumn))
+		}
+		properties = append(properties, "title="+escapeGitHubProperty(diag.Tool+" "+diag.Code))
+
+		_, err := fmt.Fprintf(
+			w,
+			"::%s %s::%s\n",
+			command,
+			strings.Join(properties, ","),
+			escapeGitHubData(diag.Message),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func escapeGitHubData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func escapeGitHubProperty(s string) string {
+	return strings.NewReplacer(":", "%3A", ",", "%2C").Replace(escapeGitHubData(s))
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes a JUnit XML report with a failed test case per
+// diagnostic, grouped into a test suite per file.
+// A clean check is reported as a single passing test case.
+func WriteJUnit(w io.Writer, diags []Diagnostic) error {
+	report := junitTestSuites{}
+	suites := map[string]int{}
+	for _, diag := range diags {
+		i, ok := suites[diag.File]
+		if !ok {
+			i = len(report.Suites)
+			suites[diag.File] = i
+			report.Suites = append(report.Suites, junitTestSuite{Name: diag.File})
+		}
+		report.Suites[i].Cases = append(report.Suites[i].Cases, junitTestCase{
+			Name:      fmt.Sprintf("%s %s", location(diag), diag.Code),
+			ClassName: diag.Tool,
+			Failure: &junitFailure{
+				Message: diag.Message,
+				Type:    string(diag.Severity),
+				Text:    fmt.Sprintf("%s: (%s) %s", location(diag), diag.Code, diag.Message),
+			},
+		})
+		report.Suites[i].Tests++
+		report.Suites[i].Failures++
+	}
+	if len(diags) == 0 {
+		report.Suites = []junitTestSuite{{
+			Name:  "kopl check",
+			Tests: 1,
+			Cases: []junitTestCase{{Name: "kopl check", ClassName: "kopl"}},
+		}}
+	}
+	for _, suite := range report.Suites {
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(report)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,250 @@
+package diagnostics
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+var sampleDiags = []Diagnostic{
+	{
+		Tool:      "luacheck",
+		Code:      "W211",
+		Severity:  Warning,
+		Message:   "unused variable 'foo'",
+		File:      "main.lua",
+		Line:      12,
+		Column:    7,
+		EndColumn: 9,
+	},
+	{
+		Tool:     "kopl",
+		Code:     "KOP001",
+		Severity: Error,
+		Message:  "missing field \"name\"\nin _meta.lua, 100%",
+		File:     "_meta.lua",
+	},
+	{
+		Tool:     "lua-language-server",
+		Code:     "undefined-field",
+		Severity: Info,
+		Message:  "field a,b: unknown",
+		File:     "lib/util.lua",
+		Line:     3,
+	},
+}
+
+func TestWriteText(t *testing.T) {
+	tests := []struct {
+		name  string
+		diags []Diagnostic
+		want  string
+	}{
+		{
+			"none",
+			nil,
+			"Total: 0 errors, 0 warnings\n",
+		},
+		{
+			"locations",
+			sampleDiags,
+			"main.lua:12:7: warning (W211) unused variable 'foo'\n" +
+				"_meta.lua: error (KOP001) missing field \"name\"\nin _meta.lua, 100%\n" +
+				"lib/util.lua:3: info (undefined-field) field a,b: unknown\n" +
+				"Total: 1 errors, 1 warnings\n",
+		},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		err := WriteText(&b, test.diags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, b.String(), test.want)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		diags []Diagnostic
+		want  int
+	}{
+		{"none is an empty array", nil, 0},
+		{"all", sampleDiags, len(sampleDiags)},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		err := WriteJSON(&b, test.diags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded []Diagnostic
+		err = json.Unmarshal(b.Bytes(), &decoded)
+		if err != nil || decoded == nil || len(decoded) != test.want {
+			t.Errorf("%s: got %s (%v)", test.name, b.String(), err)
+		}
+	}
+}
+
+func TestWriteGitHub(t *testing.T) {
+	tests := []struct {
+		name string
+		diag Diagnostic
+		want string
+	}{
+		{
+			"warning with range",
+			sampleDiags[0],
+			"::warning file=main.lua,line=12,col=7,endColumn=9,title=luacheck W211::unused variable 'foo'\n",
+		},
+		{
+			"error about a file, escaped",
+			sampleDiags[1],
+			"::error file=_meta.lua,title=kopl KOP001::missing field \"name\"%0Ain _meta.lua, 100%25\n",
+		},
+		{
+			"info is a notice",
+			sampleDiags[2],
+			"::notice file=lib/util.lua,line=3,title=lua-language-server undefined-field::field a,b: unknown\n",
+		},
+		{
+			"properties escaped",
+			Diagnostic{Tool: "t", Code: "a:b,c", Severity: Warning, Message: "m", File: "a,b:c.lua"},
+			"::warning file=a%2Cb%3Ac.lua,title=t a%3Ab%2Cc::m\n",
+		},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		err := WriteGitHub(&b, []Diagnostic{test.diag})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, b.String(), test.want)
+		}
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	tests := []struct {
+		name     string
+		diags    []Diagnostic
+		suites   []string
+		tests    int
+		failures int
+	}{
+		{"clean check passes", nil, []string{"kopl check"}, 1, 0},
+		{"suite per file", sampleDiags, []string{"main.lua", "_meta.lua", "lib/util.lua"}, 3, 3},
+		{
+			"same file shares a suite",
+			[]Diagnostic{sampleDiags[0], sampleDiags[0]},
+			[]string{"main.lua"},
+			2,
+			2,
+		},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		err := WriteJUnit(&b, test.diags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(b.String(), xml.Header) {
+			t.Errorf("%s: no XML header in\n%s", test.name, b.String())
+		}
+
+		var report junitTestSuites
+		err = xml.Unmarshal(b.Bytes(), &report)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		var suites []string
+		for _, suite := range report.Suites {
+			suites = append(suites, suite.Name)
+		}
+		if strings.Join(suites, "|") != strings.Join(test.suites, "|") ||
+			report.Tests != test.tests ||
+			report.Failures != test.failures {
+			t.Errorf(
+				"%s: got suites %v, %d tests, %d failures, want %v, %d, %d",
+				test.name, suites, report.Tests, report.Failures, test.suites, test.tests, test.failures,
+			)
+		}
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	diags := append([]Diagnostic{sampleDiags[0]}, sampleDiags...)
+	var b bytes.Buffer
+	err := WriteSARIF(&b, diags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	err = json.Unmarshal(b.Bytes(), &log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" || log.Schema != sarifSchema {
+		t.Errorf("got version %q, schema %q", log.Version, log.Schema)
+	}
+
+	tests := []struct {
+		tool    string
+		uri     string
+		rules   int
+		results int
+		level   string
+		region  *sarifRegion
+	}{
+		{
+			"luacheck",
+			toolURIs["luacheck"],
+			1,
+			2,
+			"warning",
+			// End column is exclusive
+			&sarifRegion{StartLine: 12, StartColumn: 7, EndColumn: 10},
+		},
+		{"kopl", "", 1, 1, "error", nil},
+		{"lua-language-server", "", 1, 1, "note", &sarifRegion{StartLine: 3}},
+	}
+	if len(log.Runs) != len(tests) {
+		t.Fatalf("got %d runs, want %d", len(log.Runs), len(tests))
+	}
+	for i, test := range tests {
+		run := log.Runs[i]
+		driver := run.Tool.Driver
+		if driver.Name != test.tool || driver.InformationURI != test.uri {
+			t.Errorf("run %d: got tool %q (%q), want %q (%q)", i, driver.Name, driver.InformationURI, test.tool, test.uri)
+		}
+		if len(driver.Rules) != test.rules || len(run.Results) != test.results {
+			t.Errorf("%s: got %d rules, %d results, want %d, %d", test.tool, len(driver.Rules), len(run.Results), test.rules, test.results)
+			continue
+		}
+		result := run.Results[0]
+		if result.Level != test.level {
+			t.Errorf("%s: got level %q, want %q", test.tool, result.Level, test.level)
+		}
+		region := result.Locations[0].PhysicalLocation.Region
+		if (region == nil) != (test.region == nil) || (region != nil && *region != *test.region) {
+			t.Errorf("%s: got region %+v, want %+v", test.tool, region, test.region)
+		}
+	}
+}
+
+func TestWriteSARIFEmpty(t *testing.T) {
+	var b bytes.Buffer
+	err := WriteSARIF(&b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"runs": []`) {
+		t.Errorf("want an empty list of runs, got\n%s", b.String())
+	}
+}
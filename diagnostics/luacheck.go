@@ -0,0 +1,66 @@
+package diagnostics
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LuacheckArgs makes luacheck print findings in the format ParseLuacheck reads.
+var LuacheckArgs = []string{"--formatter", "plain", "--codes", "--ranges", "--no-color"}
+
+var (
+	// main.lua:12:7-9: (W211) unused variable 'foo'
+	luacheckFinding = regexp.MustCompile(`^(.+?):(\d+):(\d+)(?:-(\d+))?: \(([EW])(\d+)\) (.*)$`)
+	// main.lua: I/O error
+	luacheckFatal = regexp.MustCompile(`^(.+?): (.*)$`)
+)
+
+// ParseLuacheck reads the output of luacheck run with LuacheckArgs.
+func ParseLuacheck(r io.Reader) ([]Diagnostic, error) {
+	var diags []Diagnostic
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		if match := luacheckFinding.FindStringSubmatch(line); match != nil {
+			diag := Diagnostic{
+				Tool:     "luacheck",
+				Code:     match[5] + match[6],
+				Severity: Warning,
+				Message:  match[7],
+				File:     cleanPath(match[1]),
+			}
+			if match[5] == "E" {
+				diag.Severity = Error
+			}
+			diag.Line, _ = strconv.Atoi(match[2])
+			diag.Column, _ = strconv.Atoi(match[3])
+			diag.EndColumn, _ = strconv.Atoi(match[4])
+			diags = append(diags, diag)
+			continue
+		}
+
+		// Files luacheck couldn't check at all
+		if match := luacheckFatal.FindStringSubmatch(line); match != nil {
+			diags = append(diags, Diagnostic{
+				Tool:     "luacheck",
+				Code:     "F",
+				Severity: Error,
+				Message:  match[2],
+				File:     cleanPath(match[1]),
+			})
+		}
+	}
+	return diags, scanner.Err()
+}
+
+func cleanPath(file string) string {
+	return path.Clean(strings.ReplaceAll(file, "\\", "/"))
+}
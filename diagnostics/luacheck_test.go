@@ -0,0 +1,96 @@
+package diagnostics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLuacheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []Diagnostic
+	}{
+		{"empty", "", nil},
+		{
+			"warning with range",
+			"main.lua:12:7-9: (W211) unused variable 'foo'\n",
+			[]Diagnostic{{
+				Tool:      "luacheck",
+				Code:      "W211",
+				Severity:  Warning,
+				Message:   "unused variable 'foo'",
+				File:      "main.lua",
+				Line:      12,
+				Column:    7,
+				EndColumn: 9,
+			}},
+		},
+		{
+			"syntax error without range",
+			"./lib/util.lua:3:1: (E011) expected 'end' near <eof>\r\n",
+			[]Diagnostic{{
+				Tool:     "luacheck",
+				Code:     "E011",
+				Severity: Error,
+				Message:  "expected 'end' near <eof>",
+				File:     "lib/util.lua",
+				Line:     3,
+				Column:   1,
+			}},
+		},
+		{
+			"windows paths",
+			"lib\\util.lua:1:1-3: (W113) accessing undefined variable 'foo'\n",
+			[]Diagnostic{{
+				Tool:      "luacheck",
+				Code:      "W113",
+				Severity:  Warning,
+				Message:   "accessing undefined variable 'foo'",
+				File:      "lib/util.lua",
+				Line:      1,
+				Column:    1,
+				EndColumn: 3,
+			}},
+		},
+		{
+			"file that couldn't be checked",
+			"missing.lua: I/O error (couldn't read: No such file or directory)\n",
+			[]Diagnostic{{
+				Tool:     "luacheck",
+				Code:     "F",
+				Severity: Error,
+				Message:  "I/O error (couldn't read: No such file or directory)",
+				File:     "missing.lua",
+			}},
+		},
+		{
+			"blank and unrecognized lines",
+			"\nnot a finding\nmain.lua:1:1: (W111) setting non-standard global variable 'x'\n",
+			[]Diagnostic{{
+				Tool:     "luacheck",
+				Code:     "W111",
+				Severity: Warning,
+				Message:  "setting non-standard global variable 'x'",
+				File:     "main.lua",
+				Line:     1,
+				Column:   1,
+			}},
+		},
+	}
+	for _, test := range tests {
+		got, err := ParseLuacheck(strings.NewReader(test.output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: got %+v, want %+v", test.name, got[i], test.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// LuaLSTool is the name lua-language-server's findings are reported under
+const LuaLSTool = "lua-language-server"
+
+// /c:/Users/... in URIs of Windows paths
+var windowsURIPath = regexp.MustCompile(`^/[A-Za-z]:`)
+
+// luaLSDiagnostic is an LSP diagnostic, as lua-language-server --check
+// writes them into check.json by file URI.
+type luaLSDiagnostic struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// 1 is error, 2 warning, 3 information and 4 hint
+	Severity int `json:"severity"`
+	Range    struct {
+		// Line and Character are 0-based, End is exclusive
+		Start struct {
+			Line      int `json:"line"`
+			Character int `json:"character"`
+		} `json:"start"`
+		End struct {
+			Line      int `json:"line"`
+			Character int `json:"character"`
+		} `json:"end"`
+	} `json:"range"`
+}
+
+// ParseLuaLS reads the check.json of lua-language-server --check run on
+// root. Findings in files outside of root are left out.
+func ParseLuaLS(data []byte, root string) ([]Diagnostic, error) {
+	var files map[string][]luaLSDiagnostic
+	err := json.Unmarshal(data, &files)
+	if err != nil {
+		return nil, err
+	}
+
+	var diags []Diagnostic
+	for uri, findings := range files {
+		file, ok := relativeToRoot(uri, root)
+		if !ok {
+			continue
+		}
+		for _, finding := range findings {
+			diag := Diagnostic{
+				Tool:     LuaLSTool,
+				Code:     finding.Code,
+				Severity: Info,
+				Message:  finding.Message,
+				File:     file,
+				Line:     finding.Range.Start.Line + 1,
+				Column:   finding.Range.Start.Character + 1,
+			}
+			switch finding.Severity {
+			case 1:
+				diag.Severity = Error
+			case 2:
+				diag.Severity = Warning
+			}
+			if finding.Range.End.Line == finding.Range.Start.Line &&
+				finding.Range.End.Character > finding.Range.Start.Character {
+				diag.EndColumn = finding.Range.End.Character
+			}
+			diags = append(diags, diag)
+		}
+	}
+	Sort(diags)
+	return diags, nil
+}
+
+func relativeToRoot(uri string, root string) (string, bool) {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "file" {
+		return "", false
+	}
+	file := parsed.Path
+	if windowsURIPath.MatchString(file) {
+		file = file[1:]
+	}
+
+	rel, err := filepath.Rel(root, filepath.FromSlash(file))
+	if err != nil {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	return cleanPath(rel), true
+}
@@ -0,0 +1,74 @@
+package diagnostics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLuaLS(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "check.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseLuaLS(data, filepath.FromSlash("/home/me/hello.koplugin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Diagnostic{
+		{
+			Tool:     LuaLSTool,
+			Code:     "trailing-space",
+			Severity: Info,
+			Message:  "Line with postspace.",
+			File:     "lib/sync state.lua",
+			Line:     3,
+			Column:   3,
+		},
+		{
+			Tool:     LuaLSTool,
+			Code:     "missing-return",
+			Severity: Info,
+			Message:  "Annotations specify that a return value is required here.",
+			File:     "lib/sync state.lua",
+			Line:     5,
+			Column:   5,
+		},
+		{
+			Tool:      LuaLSTool,
+			Code:      "unused-local",
+			Severity:  Warning,
+			Message:   "Unused local `x`.",
+			File:      "main.lua",
+			Line:      1,
+			Column:    7,
+			EndColumn: 7,
+		},
+		{
+			Tool:      LuaLSTool,
+			Code:      "undefined-global",
+			Severity:  Error,
+			Message:   "Undefined global `Devcie`.",
+			File:      "main.lua",
+			Line:      12,
+			Column:    9,
+			EndColumn: 14,
+		},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("got %+v, want %+v", got[i], want[i])
+		}
+	}
+}
+
+func TestParseLuaLSInvalid(t *testing.T) {
+	_, err := ParseLuaLS([]byte("not json"), "/")
+	if err == nil {
+		t.Error("got no error for invalid JSON")
+	}
+}
@@ -0,0 +1,128 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+)
+
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+// Where the codes of each tool are documented
+var toolURIs = map[string]string{
+	"luacheck": "https://luacheck.readthedocs.io/en/stable/warnings.html",
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri,omitempty"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+	// Exclusive, unlike Diagnostic.EndColumn
+	EndColumn int `json:"endColumn,omitempty"`
+}
+
+// WriteSARIF writes a SARIF 2.1.0 log with a run per tool, as accepted
+// by GitHub code scanning.
+func WriteSARIF(w io.Writer, diags []Diagnostic) error {
+	log := sarifLog{Schema: sarifSchema, Version: "2.1.0", Runs: []sarifRun{}}
+	runs := map[string]int{}
+	for _, diag := range diags {
+		i, ok := runs[diag.Tool]
+		if !ok {
+			i = len(log.Runs)
+			runs[diag.Tool] = i
+			log.Runs = append(log.Runs, sarifRun{
+				Tool: sarifTool{Driver: sarifDriver{
+					Name:           diag.Tool,
+					InformationURI: toolURIs[diag.Tool],
+					Rules:          []sarifRule{},
+				}},
+				Results: []sarifResult{},
+			})
+		}
+		run := &log.Runs[i]
+
+		if !slices.Contains(run.Tool.Driver.Rules, sarifRule{ID: diag.Code}) {
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: diag.Code})
+		}
+
+		location := sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifactLocation{URI: diag.File},
+		}}
+		if diag.Line > 0 {
+			region := &sarifRegion{StartLine: diag.Line, StartColumn: diag.Column}
+			if diag.EndColumn > 0 {
+				region.EndColumn = diag.EndColumn + 1
+			}
+			location.PhysicalLocation.Region = region
+		}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    diag.Code,
+			Level:     sarifLevel(diag.Severity),
+			Message:   sarifMessage{Text: diag.Message},
+			Locations: []sarifLocation{location},
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(log)
+}
+
+func sarifLevel(severity Severity) string {
+	switch severity {
+	case Error:
+		return "error"
+	case Info:
+		return "note"
+	}
+	return "warning"
+}
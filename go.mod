@@ -3,6 +3,7 @@ module github.com/Consoleaf/kopl
 go 1.24.4
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/charmbracelet/lipgloss v1.1.0
 	github.com/chzyer/readline v1.5.1
 	github.com/go-git/go-git/v5 v5.16.2
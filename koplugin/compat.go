@@ -0,0 +1,56 @@
+package koplugin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// KOReader revisions look like "v2024.04-91-g1a2b3c4_2024-05-09"
+var koreaderRevision = regexp.MustCompile(`^v?(\d+)\.(\d+)(?:\.(\d+))?(?:-(\d+))?`)
+
+// KOReaderVersion turns a KOReader revision into a comparable semantic
+// version, counting commits since the release as the patch number:
+// "v2024.04-91-g1a2b3c4" becomes "v2024.4.91".
+// Returns "" if revision isn't recognized.
+func KOReaderVersion(revision string) string {
+	match := koreaderRevision.FindStringSubmatch(strings.TrimSpace(revision))
+	if match == nil {
+		return ""
+	}
+	patch := match[3]
+	if patch == "" {
+		patch = match[4]
+	}
+	if patch == "" {
+		patch = "0"
+	}
+	return CanonicalVersion(fmt.Sprintf(
+		"v%s.%s.%s",
+		trimZeros(match[1]),
+		trimZeros(match[2]),
+		trimZeros(patch),
+	))
+}
+
+func trimZeros(number string) string {
+	trimmed := strings.TrimLeft(number, "0")
+	if trimmed == "" {
+		return "0"
+	}
+	return trimmed
+}
+
+// SupportsDevice reports whether model (like "KindlePaperWhite5") matches
+// one of the supported device families (like "kindle"), case-insensitively.
+// Blank families match no device.
+func SupportsDevice(supported []string, model string) bool {
+	model = strings.ToLower(model)
+	for _, family := range supported {
+		family = strings.TrimSpace(family)
+		if family != "" && strings.HasPrefix(model, strings.ToLower(family)) {
+			return true
+		}
+	}
+	return false
+}
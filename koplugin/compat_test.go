@@ -0,0 +1,51 @@
+package koplugin
+
+import "testing"
+
+func TestKOReaderVersion(t *testing.T) {
+	tests := []struct {
+		revision string
+		want     string
+	}{
+		{"v2024.04", "v2024.4.0"},
+		{"2024.04", "v2024.4.0"},
+		{"v2024.04-91-g1a2b3c4_2024-05-09", "v2024.4.91"},
+		{"v2023.10.1", "v2023.10.1"},
+		{"v2023.10.1-5-gabcdef0", "v2023.10.1"},
+		{" v2024.04\n", "v2024.4.0"},
+		{"v2024.004-007", "v2024.4.7"},
+
+		{"", ""},
+		{"nightly", ""},
+		{"v2024", ""},
+		{"2024-04", ""},
+		{"vX.Y", ""},
+	}
+	for _, test := range tests {
+		if got := KOReaderVersion(test.revision); got != test.want {
+			t.Errorf("KOReaderVersion(%q) = %q, want %q", test.revision, got, test.want)
+		}
+	}
+}
+
+func TestSupportsDevice(t *testing.T) {
+	tests := []struct {
+		supported []string
+		model     string
+		want      bool
+	}{
+		{[]string{"kindle"}, "KindlePaperWhite5", true},
+		{[]string{"Kobo", "kindle"}, "kindle", true},
+		{[]string{"kobo"}, "Kobo_spaBW", true},
+		{[]string{"kobo"}, "KindleOasis", false},
+		{[]string{"kindle"}, "", false},
+		{[]string{"kindle"}, "UnknownDevice", false},
+		{[]string{"", " "}, "KindleOasis", false},
+		{nil, "KindleOasis", false},
+	}
+	for _, test := range tests {
+		if got := SupportsDevice(test.supported, test.model); got != test.want {
+			t.Errorf("SupportsDevice(%q, %q) = %v, want %v", test.supported, test.model, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package koplugin
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+const ConfigFile = "kopl.toml"
+
+// DefaultVendorDir is where rocks and library dependencies are vendored
+// into the plugin unless kopl.toml says otherwise
+const DefaultVendorDir = "vendor"
+
+// Config is the project configuration in kopl.toml.
+type Config struct {
+	// Dependencies maps repositories to version constraints
+	Dependencies map[string]string `toml:"dependencies,omitempty"`
+	// Rocks maps LuaRocks packages vendored into the plugin to their versions
+	Rocks map[string]string `toml:"rocks,omitempty"`
+	// VendorDir is set by `kopl add --vendor-dir`
+	VendorDir string      `toml:"vendor_dir,omitempty"`
+	Check     CheckConfig `toml:"check,omitempty"`
+}
+
+// CheckConfig configures `kopl check`.
+type CheckConfig struct {
+	// Disable lists IDs or names of rules not to run
+	Disable []string `toml:"disable,omitempty"`
+	// KOReaderVersions are release tags of KOReader to check requires against
+	KOReaderVersions []string `toml:"koreader_versions,omitempty"`
+}
+
+// ReadConfig reads kopl.toml in pluginDir. A missing file is an empty config.
+func ReadConfig(pluginDir string) (*Config, error) {
+	src, err := os.ReadFile(filepath.Join(pluginDir, ConfigFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return ParseConfig(src)
+}
+
+// ParseConfig parses the contents of kopl.toml.
+func ParseConfig(src []byte) (*Config, error) {
+	config := &Config{}
+	_, err := toml.Decode(string(src), config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Dependencies == nil {
+		config.Dependencies = map[string]string{}
+	}
+	return config, nil
+}
+
+// Vendor returns the directory inside the plugin rocks and library
+// dependencies are vendored into.
+func (c *Config) Vendor() string {
+	if c.VendorDir == "" {
+		return DefaultVendorDir
+	}
+	return c.VendorDir
+}
+
+// Write saves the config as kopl.toml in pluginDir.
+func (c *Config) Write(pluginDir string) error {
+	f, err := os.Create(filepath.Join(pluginDir, ConfigFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return toml.NewEncoder(f).Encode(c)
+}
@@ -0,0 +1,145 @@
+package koplugin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// Constraint is a set of version requirements that all have to hold,
+// e.g. ">=v1.2.0, <v2". An empty constraint or "*" allows any version.
+type Constraint struct {
+	raw   string
+	terms []term
+}
+
+type term struct {
+	op      string
+	version string
+	// components is how many of major, minor and patch were written
+	components int
+}
+
+var operators = []string{">=", "<=", "!=", "==", ">", "<", "=", "^", "~"}
+
+// ParseConstraint parses comma-separated terms of an operator and a version.
+// Without an operator, the version has to match exactly. `^` and `~` follow
+// Cargo: "^1.2" allows up to v2, "^0.2" up to v0.3 and "^0.0.3" only
+// v0.0.3, while "~1.2" allows up to v1.3 and "~1" up to v2.
+func ParseConstraint(s string) (Constraint, error) {
+	constraint := Constraint{raw: strings.TrimSpace(s)}
+	if constraint.raw == "" || constraint.raw == "*" {
+		return constraint, nil
+	}
+
+	for _, part := range strings.Split(constraint.raw, ",") {
+		part = strings.TrimSpace(part)
+		op := "="
+		for _, candidate := range operators {
+			if strings.HasPrefix(part, candidate) {
+				op = candidate
+				part = strings.TrimSpace(strings.TrimPrefix(part, candidate))
+				break
+			}
+		}
+		version := CanonicalVersion(part)
+		if version == "" {
+			return Constraint{}, fmt.Errorf("invalid version %q in constraint %q", part, s)
+		}
+		constraint.terms = append(constraint.terms, term{
+			op:         op,
+			version:    version,
+			components: strings.Count(strings.FieldsFunc(part, isVersionSuffix)[0], ".") + 1,
+		})
+	}
+	return constraint, nil
+}
+
+// isVersionSuffix reports whether r starts a pre-release or build suffix.
+func isVersionSuffix(r rune) bool {
+	return r == '-' || r == '+'
+}
+
+// Allows reports whether version satisfies every term of the constraint.
+func (c Constraint) Allows(version string) bool {
+	version = CanonicalVersion(version)
+	if version == "" {
+		return len(c.terms) == 0
+	}
+	for _, t := range c.terms {
+		cmp := semver.Compare(version, t.version)
+		var ok bool
+		switch t.op {
+		case "=", "==":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		case "^", "~":
+			ok = cmp >= 0 && semver.Compare(version, t.upperBound()) < 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// upperBound returns the first version a `^` or `~` term doesn't allow.
+func (t term) upperBound() string {
+	core := strings.TrimPrefix(strings.TrimSuffix(t.version, semver.Prerelease(t.version)), "v")
+	var parts [3]int
+	for i, part := range strings.Split(core, ".") {
+		parts[i], _ = strconv.Atoi(part)
+	}
+
+	// The component that's bumped for the bound
+	var bump int
+	switch {
+	case t.op == "~" && t.components == 1:
+		bump = 0
+	case t.op == "~":
+		bump = 1
+	default:
+		// The leftmost non-zero written component, or the last written one
+		bump = t.components - 1
+		for i := 0; i < bump; i++ {
+			if parts[i] != 0 {
+				bump = i
+				break
+			}
+		}
+	}
+
+	parts[bump]++
+	for i := bump + 1; i < 3; i++ {
+		parts[i] = 0
+	}
+	return fmt.Sprintf("v%d.%d.%d", parts[0], parts[1], parts[2])
+}
+
+func (c Constraint) String() string {
+	if c.raw == "" {
+		return "*"
+	}
+	return c.raw
+}
+
+// CanonicalVersion turns "1.2" or "v1.2" into "v1.2.0".
+// Returns "" for anything that isn't a semantic version.
+func CanonicalVersion(version string) string {
+	version = strings.TrimSpace(version)
+	if version != "" && !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	return semver.Canonical(version)
+}
@@ -0,0 +1,83 @@
+package koplugin
+
+import "testing"
+
+func TestParseConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		ok         bool
+	}{
+		{"", true},
+		{"*", true},
+		{"v1.2.3", true},
+		{">=1.0, <v2", true},
+		{" ^0.2 ", true},
+		{"~1.2.3-beta", true},
+		{"latest", false},
+		{">=1.0, banana", false},
+		{"^", false},
+	}
+	for _, test := range tests {
+		_, err := ParseConstraint(test.constraint)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("ParseConstraint(%q) error = %v, want ok %v", test.constraint, err, test.ok)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"", "v1.0.0", true},
+		{"*", "", true},
+		{">=1.0", "", false},
+		{">=1.0", "not a version", false},
+
+		{"1.2", "v1.2.0", true},
+		{"=1.2", "1.2.1", false},
+		{"==v1.2.0", "1.2", true},
+		{"!=1.2", "1.2.0", false},
+		{"!=1.2", "1.3", true},
+		{">1.2", "1.2.0", false},
+		{">1.2", "1.2.1", true},
+		{"<=1.2", "1.2.0", true},
+		{"<1.2", "1.2.0", false},
+		{">=v0.0.3, <v1", "v0.9.9", true},
+		{">=v0.0.3, <v1", "v1.0.0", false},
+		{">=v0.0.3, <v1", "v0.0.2", false},
+
+		{"^1.2.3", "1.2.3", true},
+		{"^1.2.3", "1.9.0", true},
+		{"^1.2.3", "2.0.0", false},
+		{"^1.2.3", "1.2.2", false},
+		{"^0.2.0", "0.2.5", true},
+		{"^0.2.0", "0.3.0", false},
+		{"^0.0.3", "0.0.3", true},
+		{"^0.0.3", "0.0.4", false},
+		{"^0.0", "0.0.9", true},
+		{"^0.0", "0.1.0", false},
+		{"^0", "0.9.0", true},
+		{"^0", "1.0.0", false},
+		{"^1", "1.5.0", true},
+
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"~1.2", "1.2.0", true},
+		{"~1", "1.9.0", true},
+		{"~1", "2.0.0", false},
+		{"~0.2", "0.2.1", true},
+		{"~0.2", "0.3.0", false},
+	}
+	for _, test := range tests {
+		constraint, err := ParseConstraint(test.constraint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := constraint.Allows(test.version); got != test.want {
+			t.Errorf("%q allows %q = %v, want %v", test.constraint, test.version, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package koplugin
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FindPlugins returns root if it's a plugin, or else the plugins in it,
+// like in a repository with several. Plugins are directories with a
+// `_meta.lua` or named like "name.koplugin".
+func FindPlugins(root string) ([]string, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "find plugins", Path: root, Err: fs.ErrInvalid}
+	}
+
+	var plugins []string
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && (strings.HasPrefix(d.Name(), ".") || d.Name() == "koreader") {
+			return filepath.SkipDir
+		}
+		if !IsPlugin(path) {
+			return nil
+		}
+		plugins = append(plugins, path)
+		// Plugins don't contain other plugins
+		return filepath.SkipDir
+	})
+	return plugins, err
+}
+
+// IsPlugin reports whether dir is a plugin rather than, e.g., a Lua library.
+func IsPlugin(dir string) bool {
+	if strings.HasSuffix(filepath.Base(dir), ".koplugin") {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(dir, MetaFile))
+	return err == nil
+}
+
+// FindUp looks for an entry with one of names in dir and its parents, up to
+// the root of the git repository dir is in, like a config or the koreader
+// submodule shared by the plugins of a repository. Returns "" if there's none.
+func FindUp(dir string, names ...string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		for _, name := range names {
+			candidate := filepath.Join(dir, name)
+			if _, err := os.Stat(candidate); err == nil {
+				return candidate
+			}
+		}
+		parent := filepath.Dir(dir)
+		if isRepoRoot(dir) || parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// isRepoRoot reports whether dir is the top of a git repository,
+// where .git is a directory, or a file in submodules and worktrees.
+func isRepoRoot(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
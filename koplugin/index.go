@@ -0,0 +1,86 @@
+package koplugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Index is a catalogue of plugins, loaded from a JSON file like:
+//
+//	{"plugins": [{"name": "repl", "description": "...", "repo": "Consoleaf/repl.koplugin", "version": "v0.0.3"}]}
+type Index struct {
+	Plugins []IndexEntry `json:"plugins"`
+}
+
+type IndexEntry struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Repo        string `json:"repo"`
+	// Version is the latest released version
+	Version string `json:"version"`
+}
+
+// LoadIndex loads an index from an http(s) URL, a file:// URL or a local path.
+func LoadIndex(source string) (*Index, error) {
+	var data []byte
+	var err error
+
+	switch {
+	case strings.HasPrefix(source, "http://"), strings.HasPrefix(source, "https://"):
+		data, err = fetch(source)
+	default:
+		data, err = os.ReadFile(strings.TrimPrefix(source, "file://"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while loading plugin index %s: %w", source, err)
+	}
+
+	index := &Index{}
+	err = json.Unmarshal(data, index)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing plugin index %s: %w", source, err)
+	}
+	return index, nil
+}
+
+func fetch(url string) ([]byte, error) {
+	res, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return io.ReadAll(res.Body)
+}
+
+// Search returns the plugins whose name, repository or description
+// contains term, case-insensitively.
+func (i *Index) Search(term string) []IndexEntry {
+	term = strings.ToLower(term)
+	var res []IndexEntry
+	for _, entry := range i.Plugins {
+		haystack := strings.ToLower(entry.Name + "\n" + entry.Repo + "\n" + entry.Description)
+		if strings.Contains(haystack, term) {
+			res = append(res, entry)
+		}
+	}
+	return res
+}
+
+// Lookup finds a plugin by name. The ".koplugin" suffix is optional.
+func (i *Index) Lookup(name string) (IndexEntry, bool) {
+	name = strings.TrimSuffix(name, ".koplugin")
+	for _, entry := range i.Plugins {
+		if strings.EqualFold(strings.TrimSuffix(entry.Name, ".koplugin"), name) {
+			return entry, true
+		}
+	}
+	return IndexEntry{}, false
+}
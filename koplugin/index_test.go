@@ -0,0 +1,64 @@
+package koplugin
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	index, err := LoadIndex(filepath.Join("testdata", "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookups := []struct {
+		name string
+		repo string
+		ok   bool
+	}{
+		{"repl", "Consoleaf/repl.koplugin", true},
+		{"repl.koplugin", "Consoleaf/repl.koplugin", true},
+		{"Hello", "example/hello.koplugin", true},
+		{"missing", "", false},
+	}
+	for _, test := range lookups {
+		entry, ok := index.Lookup(test.name)
+		if ok != test.ok || entry.Repo != test.repo {
+			t.Errorf("Lookup(%q) = %q, %v, want %q, %v", test.name, entry.Repo, ok, test.repo, test.ok)
+		}
+	}
+
+	searches := []struct {
+		term  string
+		names []string
+	}{
+		{"REPL", []string{"repl"}},
+		{"example/", []string{"hello"}},
+		{"saying", []string{"hello"}},
+		{".koplugin", []string{"repl", "hello"}},
+		{"nothing like this", nil},
+	}
+	for _, test := range searches {
+		var names []string
+		for _, entry := range index.Search(test.term) {
+			names = append(names, entry.Name)
+		}
+		if !slices.Equal(names, test.names) {
+			t.Errorf("Search(%q) = %v, want %v", test.term, names, test.names)
+		}
+	}
+}
+
+func TestLoadIndexErrors(t *testing.T) {
+	for _, source := range []string{
+		filepath.Join("testdata", "missing.json"),
+		"file://" + filepath.Join("testdata", "missing.json"),
+		filepath.Join("testdata"),
+	} {
+		_, err := LoadIndex(source)
+		if err == nil {
+			t.Errorf("LoadIndex(%q) succeeded, want an error", source)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Package koplugin describes a KOReader plugin project: its `_meta.lua`,
+// kopl.toml configuration and dependencies.
+package koplugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Consoleaf/kopl/luasyntax"
+)
+
+const MetaFile = "_meta.lua"
+
+// Meta is the parsed `_meta.lua` of a plugin.
+type Meta struct {
+	Name        string
+	Fullname    string
+	Description string
+	Version     string
+	// MinKOReaderVersion is the oldest KOReader release the plugin works with
+	MinKOReaderVersion string
+	// SupportedDevices lists device families like "kindle" or "kobo".
+	// Empty means any device.
+	SupportedDevices []string
+	// Dependencies maps repositories to version constraints
+	Dependencies map[string]string
+
+	Table *luasyntax.Table
+}
+
+// MetaError is an error in the contents of a `_meta.lua`.
+type MetaError struct {
+	Path string
+	// Line and Column are 1-based, or 0 if the error has no position
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *MetaError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.Path, e.Line, e.Column, e.Message)
+}
+
+// ReadMeta parses `_meta.lua` in pluginDir. Errors in its contents
+// are a *MetaError.
+func ReadMeta(pluginDir string) (*Meta, error) {
+	metaPath := filepath.Join(pluginDir, MetaFile)
+	src, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := ParseMeta(src)
+	if err != nil {
+		metaErr := &MetaError{Path: metaPath, Message: err.Error()}
+		var syntaxErr *luasyntax.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			metaErr.Line, metaErr.Column, metaErr.Message = syntaxErr.Line, syntaxErr.Column, syntaxErr.Message
+		}
+		return nil, metaErr
+	}
+	return meta, nil
+}
+
+func ParseMeta(src []byte) (*Meta, error) {
+	table, err := luasyntax.ParseReturnedTable(string(src))
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &Meta{
+		Name:         table.String("name"),
+		Fullname:     table.String("fullname"),
+		Description:  table.String("description"),
+		Version:      table.String("version"),
+		Dependencies: map[string]string{},
+		Table:        table,
+
+		MinKOReaderVersion: table.String("min_koreader_version"),
+	}
+
+	if devices := table.Table("supported_devices"); devices != nil {
+		meta.SupportedDevices = devices.Strings()
+	}
+
+	if dependencies := table.Table("dependencies"); dependencies != nil {
+		for _, field := range dependencies.Fields {
+			switch key := field.Key.(type) {
+			case string:
+				// ["owner/repo"] = ">=v1.0.0"
+				constraint, _ := field.Value.(string)
+				meta.Dependencies[key] = constraint
+			case int:
+				// "owner/repo"
+				if repo, ok := field.Value.(string); ok {
+					meta.Dependencies[repo] = ""
+				}
+			}
+		}
+	}
+
+	return meta, nil
+}
@@ -0,0 +1,103 @@
+package koplugin
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseMeta(t *testing.T) {
+	src := strings.Join([]string{
+		`local _ = require("gettext")`,
+		`return {`,
+		`    name = "hello",`,
+		`    fullname = _("Hello"),`,
+		`    version = "1.2.0",`,
+		`    min_koreader_version = "2024.04",`,
+		`    supported_devices = { "kindle", "kobo" },`,
+		`    dependencies = {`,
+		`        ["Consoleaf/repl.koplugin"] = ">=v0.0.3, <v1",`,
+		`        "example/lib",`,
+		`        ["example/unversioned"] = true,`,
+		`    },`,
+		`}`,
+	}, "\n")
+
+	meta, err := ParseMeta([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Name != "hello" || meta.Fullname != "Hello" || meta.Version != "1.2.0" {
+		t.Errorf("got name %q, fullname %q, version %q", meta.Name, meta.Fullname, meta.Version)
+	}
+	if meta.MinKOReaderVersion != "2024.04" {
+		t.Errorf("min_koreader_version = %q", meta.MinKOReaderVersion)
+	}
+	if !slices.Equal(meta.SupportedDevices, []string{"kindle", "kobo"}) {
+		t.Errorf("supported_devices = %v", meta.SupportedDevices)
+	}
+
+	want := map[string]string{
+		"Consoleaf/repl.koplugin": ">=v0.0.3, <v1",
+		"example/lib":             "",
+		// Constraints that aren't strings allow any version
+		"example/unversioned": "",
+	}
+	if !maps.Equal(meta.Dependencies, want) {
+		t.Errorf("dependencies = %v, want %v", meta.Dependencies, want)
+	}
+}
+
+func TestParseMetaWithoutDependencies(t *testing.T) {
+	meta, err := ParseMeta([]byte(`return { name = "hello" }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Dependencies == nil || len(meta.Dependencies) != 0 {
+		t.Errorf("dependencies = %#v, want an empty map", meta.Dependencies)
+	}
+	if meta.SupportedDevices != nil {
+		t.Errorf("supported_devices = %v, want none", meta.SupportedDevices)
+	}
+}
+
+func TestReadMetaErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		line   int
+		column int
+		want   string
+	}{
+		{"syntax error", "return {\n  name = 'hello\n}", 2, 16, "unfinished string"},
+		{"no table", "return nil", 0, 0, "chunk doesn't return a table constructor"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := os.WriteFile(filepath.Join(dir, MetaFile), []byte(test.src), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = ReadMeta(dir)
+			var metaErr *MetaError
+			if !errors.As(err, &metaErr) {
+				t.Fatalf("got %v, want a *MetaError", err)
+			}
+			if metaErr.Path != filepath.Join(dir, MetaFile) || metaErr.Line != test.line ||
+				metaErr.Column != test.column || metaErr.Message != test.want {
+				t.Errorf("got %#v, want %d:%d: %s", metaErr, test.line, test.column, test.want)
+			}
+		})
+	}
+
+	_, err := ReadMeta(t.TempDir())
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: got %v, want os.ErrNotExist", err)
+	}
+}
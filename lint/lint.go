@@ -0,0 +1,170 @@
+// Package lint finds mistakes specific to KOReader plugins, which a general
+// Lua linter like luacheck can't see.
+package lint
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Consoleaf/kopl/diagnostics"
+	"github.com/Consoleaf/kopl/koplugin"
+	"github.com/Consoleaf/kopl/luasyntax"
+)
+
+// Tool is what diagnostics of the rules are attributed to
+const Tool = "kopl"
+
+// Plugin is the plugin project being linted.
+type Plugin struct {
+	Dir string
+	// Meta is nil if `_meta.lua` is missing or can't be parsed
+	Meta    *koplugin.Meta
+	MetaErr error
+	// Files are the plugin's Lua files, koreader and hidden directories aside
+	Files []*File
+	// KOReaders are other KOReader versions to check against, besides
+	// the koreader submodule
+	KOReaders []KOReaderTree
+	// KOReaderDir is the koreader submodule in the plugin or a directory
+	// above it in the repository, "" if there's none
+	KOReaderDir string
+	// VendorDir is where rocks and libraries are vendored, relative to Dir
+	VendorDir string
+}
+
+// File is a Lua file of the plugin.
+type File struct {
+	// Path is slash-separated and relative to the plugin directory
+	Path   string
+	Tokens []luasyntax.Token
+	// Err is set if the file couldn't be read or tokenized
+	Err error
+}
+
+// File returns the file with the given path, or nil.
+func (p *Plugin) File(path string) *File {
+	for _, file := range p.Files {
+		if file.Path == path {
+			return file
+		}
+	}
+	return nil
+}
+
+// Rule is a check run over the whole plugin.
+type Rule struct {
+	// ID like "KOP001", used to disable the rule
+	ID string
+	// Name is an alternative to the ID, like "meta-fields"
+	Name        string
+	Description string
+	Severity    diagnostics.Severity
+	Check       func(p *Plugin, r *Reporter)
+}
+
+// Reporter collects the diagnostics of a rule.
+type Reporter struct {
+	rule  Rule
+	diags []diagnostics.Diagnostic
+}
+
+// At reports a finding at a position. line and column may be 0.
+func (r *Reporter) At(file string, line int, column int, format string, args ...any) {
+	r.diags = append(r.diags, diagnostics.Diagnostic{
+		Tool:     Tool,
+		Code:     r.rule.ID,
+		Severity: r.rule.Severity,
+		Message:  fmt.Sprintf(format, args...),
+		File:     file,
+		Line:     line,
+		Column:   column,
+	})
+}
+
+// AtToken reports a finding at a token.
+func (r *Reporter) AtToken(file string, token luasyntax.Token, format string, args ...any) {
+	r.At(file, token.Line, token.Column, format, args...)
+}
+
+// Load reads the plugin in dir.
+func Load(dir string) (*Plugin, error) {
+	plugin := &Plugin{Dir: dir}
+	plugin.Meta, plugin.MetaErr = koplugin.ReadMeta(dir)
+	config, err := koplugin.ReadConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	plugin.VendorDir = config.Vendor()
+	plugin.KOReaderDir = koplugin.FindUp(dir, "koreader")
+
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && (strings.HasPrefix(d.Name(), ".") || d.Name() == "koreader") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".lua" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		file := &File{Path: filepath.ToSlash(rel)}
+		src, err := os.ReadFile(path)
+		if err == nil {
+			file.Tokens, err = luasyntax.Tokenize(string(src))
+		}
+		file.Err = err
+		plugin.Files = append(plugin.Files, file)
+		return nil
+	})
+	return plugin, err
+}
+
+// Options configure a run of the rules.
+type Options struct {
+	// Disabled rules, by ID or name
+	Disabled []string
+	// KOReaders are other KOReader versions to check against
+	KOReaders []KOReaderTree
+}
+
+// Run runs the rules over the plugin in dir.
+func Run(dir string, options Options) ([]diagnostics.Diagnostic, error) {
+	plugin, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	plugin.KOReaders = options.KOReaders
+
+	var diags []diagnostics.Diagnostic
+	for _, rule := range Rules {
+		if slices.Contains(options.Disabled, rule.ID) || slices.Contains(options.Disabled, rule.Name) {
+			continue
+		}
+		reporter := &Reporter{rule: rule}
+		rule.Check(plugin, reporter)
+		diags = append(diags, reporter.diags...)
+	}
+	return diags, nil
+}
+
+// Lookup returns the rule with the given ID or name.
+func Lookup(idOrName string) (Rule, bool) {
+	for _, rule := range Rules {
+		if rule.ID == idOrName || rule.Name == idOrName {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
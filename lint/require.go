@@ -0,0 +1,150 @@
+package lint
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Consoleaf/kopl/luasyntax"
+)
+
+// Modules built into LuaJIT or KOReader's binaries, which aren't in the
+// koreader source tree
+var runtimeModules = map[string]bool{
+	"bit": true, "ffi": true, "jit": true, "jit.util": true, "jit.profile": true,
+	"string.buffer": true, "table.new": true, "table.clear": true,
+	"lfs": true, "libs/libkoreader-lfs": true, "lpeg": true, "rapidjson": true,
+	"socket": true, "socket.core": true, "socket.http": true, "socket.url": true,
+	"socket.ftp": true, "socket.smtp": true, "ltn12": true, "mime": true,
+	"ssl": true, "ssl.https": true, "zmq": true,
+}
+
+// Require is a `require` of a module given as a string literal.
+type Require struct {
+	Module string
+	// Token is the string literal
+	Token luasyntax.Token
+}
+
+// Requires finds the `require "module"` and `require("module")` calls in tokens.
+func Requires(tokens []luasyntax.Token) []Require {
+	var requires []Require
+	for i := 0; i+1 < len(tokens); i++ {
+		if !tokens[i].Is(luasyntax.Name, "require") {
+			continue
+		}
+		next := tokens[i+1]
+		if next.Is(luasyntax.Symbol, "(") && i+3 < len(tokens) && tokens[i+3].Is(luasyntax.Symbol, ")") {
+			next = tokens[i+2]
+		}
+		if next.Kind == luasyntax.String {
+			requires = append(requires, Require{Module: next.Value, Token: next})
+		}
+	}
+	return requires
+}
+
+// moduleExists tells if module is a Lua file in one of roots, following
+// package.path's "?.lua" and "?/init.lua" patterns.
+func moduleExists(module string, roots ...string) bool {
+	name := filepath.FromSlash(strings.ReplaceAll(module, ".", "/"))
+	for _, root := range roots {
+		for _, candidate := range []string{name + ".lua", filepath.Join(name, "init.lua")} {
+			if _, err := os.Stat(filepath.Join(root, candidate)); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// KOReaderTree is a checkout of KOReader's sources to resolve modules in.
+type KOReaderTree struct {
+	// Name describes the tree in messages, e.g. "KOReader v2024.04"
+	Name string
+	Dir  string
+}
+
+// roots are the directories KOReader's package.path points into.
+// koreader-base's modules are copied next to frontend's in releases.
+func (t KOReaderTree) roots() []string {
+	return []string{
+		filepath.Join(t.Dir, "frontend"),
+		filepath.Join(t.Dir, "base"),
+		filepath.Join(t.Dir, "common"),
+		t.Dir,
+	}
+}
+
+// resolves tells if module is in the tree. ok is false if that can't be
+// told because the tree, or the nested koreader-base submodule for a
+// module in it, isn't checked out.
+func (t KOReaderTree) resolves(module string) (found bool, ok bool) {
+	if !isPopulated(filepath.Join(t.Dir, "frontend")) {
+		return false, false
+	}
+	if moduleExists(module, t.roots()...) {
+		return true, true
+	}
+	if isBaseModule(module) && !isPopulated(filepath.Join(t.Dir, "base")) {
+		return false, false
+	}
+	return false, true
+}
+
+// Calls like `pcall(require, "module")` load optional modules and aren't
+// checked, since they aren't matched by Requires.
+func checkRequires(p *Plugin, r *Reporter) {
+	var trees []KOReaderTree
+	if p.KOReaderDir != "" {
+		trees = append(trees, KOReaderTree{Name: "the koreader submodule", Dir: p.KOReaderDir})
+	}
+	trees = append(trees, p.KOReaders...)
+	// KOReader puts the plugin's directory into package.path
+	pluginRoots := []string{p.Dir, filepath.Join(p.Dir, p.VendorDir)}
+
+	for _, file := range p.Files {
+		if file.Err != nil {
+			continue
+		}
+		for _, require := range Requires(file.Tokens) {
+			if runtimeModules[require.Module] || moduleExists(require.Module, pluginRoots...) {
+				continue
+			}
+
+			var missing []string
+			for _, tree := range trees {
+				found, ok := tree.resolves(require.Module)
+				if ok && !found {
+					missing = append(missing, tree.Name)
+				}
+			}
+			if len(missing) > 0 {
+				r.AtToken(
+					file.Path,
+					require.Token,
+					"module %q can't be found in %s",
+					require.Module,
+					strings.Join(missing, " and "),
+				)
+			}
+		}
+	}
+}
+
+// isPopulated tells if dir exists and has anything in it, unlike the
+// directories of submodules that aren't checked out.
+func isPopulated(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	return err == nil && len(entries) > 0
+}
+
+// isBaseModule tells if module would be in koreader-base
+func isBaseModule(module string) bool {
+	for _, prefix := range []string{"ffi/", "ffi.", "libs/", "common/"} {
+		if strings.HasPrefix(module, prefix) {
+			return true
+		}
+	}
+	return false
+}
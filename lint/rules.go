@@ -0,0 +1,252 @@
+package lint
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/Consoleaf/kopl/diagnostics"
+	"github.com/Consoleaf/kopl/koplugin"
+	"github.com/Consoleaf/kopl/luasyntax"
+)
+
+const mainFile = "main.lua"
+
+// Rules are all the rules, in the order they run
+var Rules = []Rule{
+	{
+		ID:          "KOP001",
+		Name:        "meta-syntax",
+		Description: "_meta.lua exists and returns a table KOReader can read",
+		Severity:    diagnostics.Error,
+		Check:       checkMetaSyntax,
+	},
+	{
+		ID:          "KOP002",
+		Name:        "meta-fields",
+		Description: "_meta.lua has a name, fullname and description",
+		Severity:    diagnostics.Warning,
+		Check:       checkMetaFields,
+	},
+	{
+		ID:          "KOP003",
+		Name:        "meta-name-directory",
+		Description: "The name in _meta.lua matches the plugin directory",
+		Severity:    diagnostics.Warning,
+		Check:       checkMetaNameDirectory,
+	},
+	{
+		ID:          "KOP004",
+		Name:        "module-name",
+		Description: "The name of the plugin module in main.lua matches _meta.lua",
+		Severity:    diagnostics.Error,
+		Check:       checkModuleName,
+	},
+	{
+		ID:          "KOP005",
+		Name:        "module-return",
+		Description: "main.lua returns the plugin module",
+		Severity:    diagnostics.Error,
+		Check:       checkModuleReturn,
+	},
+	{
+		ID:          "KOP006",
+		Name:        "untranslated-string",
+		Description: "Strings shown in the UI are wrapped in _() to be translated",
+		Severity:    diagnostics.Warning,
+		Check:       checkUntranslatedStrings,
+	},
+	{
+		ID:          "KOP007",
+		Name:        "unresolved-require",
+		Description: "Required modules exist in the plugin and KOReader",
+		Severity:    diagnostics.Error,
+		Check:       checkRequires,
+	},
+}
+
+func checkMetaSyntax(p *Plugin, r *Reporter) {
+	if p.MetaErr == nil {
+		return
+	}
+	if errors.Is(p.MetaErr, os.ErrNotExist) {
+		r.At(koplugin.MetaFile, 0, 0, "%s is missing", koplugin.MetaFile)
+		return
+	}
+
+	var metaErr *koplugin.MetaError
+	if errors.As(p.MetaErr, &metaErr) {
+		r.At(koplugin.MetaFile, metaErr.Line, metaErr.Column, "%s", metaErr.Message)
+		return
+	}
+	r.At(koplugin.MetaFile, 0, 0, "%s", p.MetaErr)
+}
+
+func checkMetaFields(p *Plugin, r *Reporter) {
+	if p.Meta == nil {
+		return
+	}
+	for _, key := range []string{"name", "fullname", "description"} {
+		field, ok := p.Meta.Table.Get(key)
+		if !ok {
+			r.At(koplugin.MetaFile, p.Meta.Table.Line, 0, "%s is missing `%s`", koplugin.MetaFile, key)
+			continue
+		}
+		// Other expressions can't be checked
+		if value, ok := field.Value.(string); ok && strings.TrimSpace(value) == "" {
+			r.At(koplugin.MetaFile, field.Line, field.Column, "`%s` is empty", key)
+		}
+	}
+}
+
+func checkMetaNameDirectory(p *Plugin, r *Reporter) {
+	if p.Meta == nil || p.Meta.Name == "" {
+		return
+	}
+	dir, err := filepath.Abs(p.Dir)
+	if err != nil {
+		return
+	}
+	// Only plugins checked out under another name could be wrong here
+	dirName, ok := strings.CutSuffix(filepath.Base(dir), ".koplugin")
+	if !ok {
+		return
+	}
+
+	// "MyPlugin" matches "my-plugin.koplugin", as created by `kopl init`
+	if normalizeName(p.Meta.Name) == normalizeName(dirName) {
+		return
+	}
+	field, _ := p.Meta.Table.Get("name")
+	r.At(
+		koplugin.MetaFile,
+		field.Line,
+		field.Column,
+		"name %q doesn't match the plugin directory %s",
+		p.Meta.Name,
+		filepath.Base(dir),
+	)
+}
+
+func normalizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if !unicode.IsLetter(r) && !unicode.IsNumber(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, name)
+}
+
+func checkModuleName(p *Plugin, r *Reporter) {
+	main := p.File(mainFile)
+	if p.Meta == nil || p.Meta.Name == "" || main == nil || main.Err != nil {
+		return
+	}
+	table := moduleTable(main.Tokens)
+	if table == nil {
+		return
+	}
+	field, ok := table.Get("name")
+	name, isString := field.Value.(string)
+	if !ok || !isString || name == p.Meta.Name {
+		return
+	}
+	r.At(
+		mainFile,
+		field.Line,
+		field.Column,
+		"module name %q doesn't match the name %q in %s",
+		name,
+		p.Meta.Name,
+		koplugin.MetaFile,
+	)
+}
+
+// moduleTable returns the table passed to `extend` when defining the module
+// main.lua returns, as in `local Plugin = WidgetContainer:extend{...}`.
+func moduleTable(tokens []luasyntax.Token) *luasyntax.Table {
+	returnAt := luasyntax.TopLevelReturn(tokens)
+	if returnAt < 0 || tokens[returnAt+1].Kind != luasyntax.Name {
+		return nil
+	}
+	module := tokens[returnAt+1].Value
+
+	for i := 0; i+5 < len(tokens); i++ {
+		if !tokens[i].Is(luasyntax.Name, module) || !tokens[i+1].Is(luasyntax.Symbol, "=") {
+			continue
+		}
+		// Skip to the `:extend` of the expression
+		j := i + 2
+		for j < len(tokens) && (tokens[j].Kind == luasyntax.Name || tokens[j].Is(luasyntax.Symbol, ".")) {
+			j++
+		}
+		if !tokens[j].Is(luasyntax.Symbol, ":") || !tokens[j+1].Is(luasyntax.Name, "extend") {
+			continue
+		}
+		brace := j + 2
+		if tokens[brace].Is(luasyntax.Symbol, "(") {
+			brace++
+		}
+		table, _, err := luasyntax.ParseTable(tokens, brace)
+		if err == nil {
+			return table
+		}
+	}
+	return nil
+}
+
+func checkModuleReturn(p *Plugin, r *Reporter) {
+	main := p.File(mainFile)
+	if main == nil {
+		r.At(mainFile, 0, 0, "%s is missing", mainFile)
+		return
+	}
+	if main.Err != nil {
+		r.At(mainFile, 0, 0, "%v", main.Err)
+		return
+	}
+	if luasyntax.TopLevelReturn(main.Tokens) >= 0 {
+		return
+	}
+	last := main.Tokens[len(main.Tokens)-1]
+	r.AtToken(mainFile, last, "%s doesn't return the plugin module", mainFile)
+}
+
+// Fields of widgets holding text shown to the user
+var uiTextFields = map[string]bool{
+	"text":        true,
+	"title":       true,
+	"subtitle":    true,
+	"info_text":   true,
+	"help_text":   true,
+	"ok_text":     true,
+	"cancel_text": true,
+	"fullname":    true,
+	"description": true,
+}
+
+func checkUntranslatedStrings(p *Plugin, r *Reporter) {
+	for _, file := range p.Files {
+		if file.Err != nil || strings.HasPrefix(file.Path, "spec/") {
+			continue
+		}
+		tokens := file.Tokens
+		for i := 1; i+2 < len(tokens); i++ {
+			key, value := tokens[i], tokens[i+2]
+			if key.Kind != luasyntax.Name || !uiTextFields[key.Value] || !tokens[i+1].Is(luasyntax.Symbol, "=") {
+				continue
+			}
+			// Only fields of table constructors
+			previous := tokens[i-1]
+			if !previous.Is(luasyntax.Symbol, "{") && !previous.Is(luasyntax.Symbol, ",") && !previous.Is(luasyntax.Symbol, ";") {
+				continue
+			}
+			if value.Kind != luasyntax.String || !strings.ContainsFunc(value.Value, unicode.IsLetter) {
+				continue
+			}
+			r.AtToken(file.Path, value, "`%s` is shown to the user, wrap it in _() to translate it", key.Value)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package lint
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	goodMeta = `local _ = require("gettext")
+return {
+    name = "hello",
+    fullname = _("Hello"),
+    description = _([[Says hello.]]),
+}
+`
+	goodMain = `local WidgetContainer = require("ui/widget/container/widgetcontainer")
+local _ = require("gettext")
+
+local Hello = WidgetContainer:extend{
+    name = "hello",
+    is_doc_only = false,
+}
+
+return Hello
+`
+)
+
+// writePlugin creates a repository with the plugin's files in a directory
+// named dirName and returns the plugin's path.
+func writePlugin(t *testing.T, dirName string, files map[string]string) string {
+	t.Helper()
+	repo := t.TempDir()
+	dir := filepath.Join(repo, dirName)
+	all := map[string]string{
+		".git/HEAD": "ref: refs/heads/main\n",
+		// The koreader submodule of the repository
+		"koreader/frontend/gettext.lua":                             "",
+		"koreader/frontend/ui/widget/container/widgetcontainer.lua": "",
+		"koreader/base/ffi/util.lua":                                "",
+	}
+	for name, content := range files {
+		all[filepath.Join(dirName, name)] = content
+	}
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range all {
+		path := filepath.Join(repo, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		dirName string
+		files   map[string]string
+		// want are "file:line" of the findings, in order
+		want []string
+	}{
+		{"meta-syntax ok", "KOP001", "hello.koplugin", map[string]string{"_meta.lua": goodMeta}, nil},
+		{"meta-syntax missing", "KOP001", "hello.koplugin", map[string]string{}, []string{"_meta.lua:0"}},
+		{
+			"meta-syntax error",
+			"KOP001",
+			"hello.koplugin",
+			map[string]string{"_meta.lua": "return {\n    name = \"hello,\n}\n"},
+			[]string{"_meta.lua:2"},
+		},
+
+		{"meta-fields ok", "KOP002", "hello.koplugin", map[string]string{"_meta.lua": goodMeta}, nil},
+		{
+			"meta-fields missing and empty",
+			"KOP002",
+			"hello.koplugin",
+			map[string]string{"_meta.lua": "return {\n    name = \"hello\",\n    fullname = \"  \",\n}\n"},
+			[]string{"_meta.lua:3", "_meta.lua:1"},
+		},
+
+		{"meta-name-directory ok", "KOP003", "hello.koplugin", map[string]string{"_meta.lua": goodMeta}, nil},
+		{
+			"meta-name-directory normalized",
+			"KOP003",
+			"hel-lo.koplugin",
+			map[string]string{"_meta.lua": goodMeta},
+			nil,
+		},
+		{
+			"meta-name-directory not a .koplugin checkout",
+			"KOP003",
+			"hello-plugin",
+			map[string]string{"_meta.lua": goodMeta},
+			nil,
+		},
+		{
+			"meta-name-directory mismatch",
+			"KOP003",
+			"bye.koplugin",
+			map[string]string{"_meta.lua": goodMeta},
+			[]string{"_meta.lua:3"},
+		},
+
+		{
+			"module-name ok",
+			"KOP004",
+			"hello.koplugin",
+			map[string]string{"_meta.lua": goodMeta, "main.lua": goodMain},
+			nil,
+		},
+		{
+			"module-name mismatch",
+			"KOP004",
+			"hello.koplugin",
+			map[string]string{
+				"_meta.lua": goodMeta,
+				"main.lua":  strings.Replace(goodMain, `name = "hello"`, `name = "bye"`, 1),
+			},
+			[]string{"main.lua:5"},
+		},
+
+		{
+			"module-return ok",
+			"KOP005",
+			"hello.koplugin",
+			map[string]string{"main.lua": goodMain},
+			nil,
+		},
+		{"module-return missing main.lua", "KOP005", "hello.koplugin", map[string]string{}, []string{"main.lua:0"}},
+		{
+			"module-return nested return only",
+			"KOP005",
+			"hello.koplugin",
+			map[string]string{"main.lua": "local M = {}\nfunction M.f()\n    return 1\nend"},
+			[]string{"main.lua:4"},
+		},
+
+		{
+			"untranslated-string ok",
+			"KOP006",
+			"hello.koplugin",
+			map[string]string{
+				"main.lua":           "local m = InfoMessage:new{ text = _(\"Hello\"), icon = \"notice\", title = \"...\" }\n",
+				"spec/main_spec.lua": "local m = { text = \"Hello\" }\n",
+			},
+			nil,
+		},
+		{
+			"untranslated-string field",
+			"KOP006",
+			"hello.koplugin",
+			map[string]string{"main.lua": "local m = InfoMessage:new{\n    timeout = 2,\n    text = \"Hello\",\n}\nm.text = \"Not a constructor\"\n"},
+			[]string{"main.lua:3"},
+		},
+
+		{
+			"unresolved-require ok",
+			"KOP007",
+			"hello.koplugin",
+			map[string]string{
+				"main.lua":           "require(\"gettext\")\nrequire \"ffi/util\"\nrequire(\"ffi\")\nrequire(\"lib\")\nrequire(\"inspect\")\npcall(require, \"missing\")\n",
+				"lib.lua":            "",
+				"vendor/inspect.lua": "",
+			},
+			nil,
+		},
+		{
+			"unresolved-require configured vendor dir",
+			"KOP007",
+			"hello.koplugin",
+			map[string]string{
+				"main.lua":         "require(\"inspect\")\n",
+				"kopl.toml":        "vendor_dir = \"libs\"\n",
+				"libs/inspect.lua": "",
+			},
+			nil,
+		},
+		{
+			"unresolved-require missing",
+			"KOP007",
+			"hello.koplugin",
+			map[string]string{"main.lua": "local x = require(\"ui/missing\")\n"},
+			[]string{"main.lua:1"},
+		},
+	}
+
+	for _, test := range tests {
+		dir := writePlugin(t, test.dirName, test.files)
+		var disabled []string
+		for _, rule := range Rules {
+			if rule.ID != test.rule {
+				disabled = append(disabled, rule.ID)
+			}
+		}
+		diags, err := Run(dir, Options{Disabled: disabled})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		var got []string
+		for _, diag := range diags {
+			if diag.Code != test.rule {
+				t.Errorf("%s: got a finding of %s", test.name, diag.Code)
+			}
+			got = append(got, fmt.Sprintf("%s:%d", diag.File, diag.Line))
+		}
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("%s: got %v, want %v\n%+v", test.name, got, test.want, diags)
+		}
+	}
+}
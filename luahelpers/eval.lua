@@ -0,0 +1,147 @@
+local env, code, max_depth = ...
+max_depth = tonumber(max_depth) or 2
+
+-- Entries serialized per table, the rest is marked as truncated
+local max_fields = 100
+
+local encodeString = require("kopl.lib").encodeString
+
+local function encode(value)
+    local t = type(value)
+    if t == "string" then
+        return encodeString(value)
+    elseif t == "number" or t == "boolean" then
+        return tostring(value)
+    elseif t == "table" then
+        local parts = {}
+        if #value > 0 or next(value) == nil then
+            for _, item in ipairs(value) do
+                parts[#parts + 1] = encode(item)
+            end
+            return "[" .. table.concat(parts, ",") .. "]"
+        end
+        for key, item in pairs(value) do
+            parts[#parts + 1] = encode(tostring(key)) .. ":" .. encode(item)
+        end
+        return "{" .. table.concat(parts, ",") .. "}"
+    end
+    return "null"
+end
+
+local function repr(value)
+    if type(value) == "string" then
+        return value
+    end
+    -- __tostring may fail
+    local ok, res = pcall(tostring, value)
+    return ok and res or type(value)
+end
+
+-- `seen` holds the tables on the current path, so shared tables are
+-- serialized twice but cycles terminate.
+local function serialize(value, depth, seen)
+    local node = { type = type(value), repr = repr(value) }
+    if node.type ~= "table" then
+        return node
+    end
+    if seen[value] then
+        node.cycle = true
+        return node
+    end
+    if depth >= max_depth then
+        node.truncated = true
+        return node
+    end
+
+    seen[value] = true
+    local fields = {}
+    for key, item in pairs(value) do
+        if #fields >= max_fields then
+            node.truncated = true
+            break
+        end
+        fields[#fields + 1] = {
+            key = serialize(key, depth + 1, seen),
+            value = serialize(item, depth + 1, seen),
+        }
+    end
+    seen[value] = nil
+
+    table.sort(fields, function(a, b)
+        if a.key.type ~= b.key.type then
+            return a.key.type < b.key.type
+        end
+        if a.key.type == "number" then
+            return tonumber(a.key.repr) < tonumber(b.key.repr)
+        end
+        return a.key.repr < b.key.repr
+    end)
+    node.fields = fields
+    return node
+end
+
+-- Typed errors, see ReplError in kopl
+local function compileError(err)
+    if err:match("<eof>'?$") then
+        return { code = "incomplete", message = err }
+    end
+    local line, message = err:match("^repl:(%d+): (.*)$")
+    if not line then
+        return { code = "syntax", message = err }
+    end
+    return {
+        code = "syntax",
+        message = message,
+        line = tonumber(line),
+        near = message:match("near '(.*)'$"),
+    }
+end
+
+local function runtimeError(err)
+    local message = repr(err)
+    -- Frames below the chunk belong to this helper
+    local frames = {}
+    for frame in debug.traceback("", 2):gmatch("\n\t([^\n]*)") do
+        if frame:match("in function 'xpcall'") then
+            break
+        end
+        frames[#frames + 1] = frame
+    end
+    return {
+        code = "runtime",
+        message = message,
+        line = tonumber((message:match("^repl:(%d+):"))),
+        traceback = frames,
+    }
+end
+
+local fn, err = loadstring("return " .. code, "=repl")
+if not fn then
+    fn, err = loadstring(code, "=repl")
+end
+if not fn then
+    return encode({ status = "error", error = compileError(err) })
+end
+setfenv(fn, env)
+
+local clock = os.clock
+local has_socket, socket = pcall(require, "socket")
+if has_socket and type(socket) == "table" and socket.gettime then
+    clock = socket.gettime
+end
+
+local function pack(...)
+    return { n = select("#", ...), ... }
+end
+local started = clock()
+local results = pack(xpcall(fn, runtimeError))
+local elapsed = clock() - started
+if not results[1] then
+    return encode({ status = "error", error = results[2], elapsed = elapsed })
+end
+
+local values = {}
+for i = 2, results.n do
+    values[#values + 1] = serialize(results[i], 0, {})
+end
+return encode({ status = "ok", values = values, elapsed = elapsed })
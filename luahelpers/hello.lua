@@ -0,0 +1,49 @@
+local env = ...
+
+local capabilities = {}
+
+-- Streaming yields from print, which may be called inside pcall
+local co = coroutine.create(function()
+    pcall(coroutine.yield)
+end)
+coroutine.resume(co)
+if coroutine.status(co) == "suspended" then
+    capabilities[#capabilities + 1] = "stream"
+end
+
+-- Stopping streamed code that doesn't print after a timeout
+if debug and debug.sethook then
+    capabilities[#capabilities + 1] = "timeout"
+end
+
+if debug and debug.traceback then
+    capabilities[#capabilities + 1] = "traceback"
+end
+
+-- Wall clock time for :time, os.clock only measures CPU time
+local has_socket, socket = pcall(require, "socket")
+if has_socket and type(socket) == "table" and socket.gettime then
+    capabilities[#capabilities + 1] = "timing"
+end
+
+-- What evaluates the chunks on the device: the protocol of the running
+-- koplbridge.koplugin and the version of the loaded repl.koplugin
+local bridge = package.loaded["koplbridge.state"]
+local bridge_protocol = bridge and tonumber(bridge.protocol) or 0
+local function instance(module)
+    return package.loaded[module] and package.loaded[module].instance
+end
+local ui = env.ui or instance("apps/reader/readerui") or instance("apps/filemanager/filemanager")
+local repl = ui and ui.Repl
+local repl_version = repl and type(repl.version) == "string" and repl.version or ""
+
+local quoted = {}
+for i, capability in ipairs(capabilities) do
+    quoted[i] = '"' .. capability .. '"'
+end
+return string.format(
+    '{"bridge":%d,"repl":"%s","capabilities":[%s]}',
+    bridge_protocol,
+    (repl_version:gsub('[%c"\\]', "")),
+    table.concat(quoted, ",")
+)
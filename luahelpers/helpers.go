@@ -0,0 +1,161 @@
+// Package luahelpers contains Lua chunks kopl runs inside KOReader.
+//
+// Helpers are installed into package.loaded on the device once and called
+// by short chunks afterwards, so that they don't have to be sent every time.
+// A helper receives the environment of the calling chunk, followed by its args.
+package luahelpers
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"strings"
+)
+
+// Missing is returned by a call chunk if the helper isn't installed
+const Missing = "kopl: helper is not installed"
+
+const (
+	// BridgePluginDir is the directory of the bridge plugin in BridgePlugin
+	BridgePluginDir = "koplbridge.koplugin"
+	// BridgePort is the local port the bridge plugin listens on.
+	// Must match PORT in its main.lua.
+	BridgePort = 8788
+	// BridgeProtocol is the version of the bridge plugin's request and
+	// response format. Must match PROTOCOL in its main.lua.
+	BridgeProtocol = 1
+)
+
+// BridgePlugin is a plugin evaluating chunks sent over a local socket,
+// for devices without HTTP Inspector.
+//
+//go:embed koplbridge.koplugin
+var BridgePlugin embed.FS
+
+const (
+	// LibModule is the name Lib is loaded as
+	LibModule = "kopl.lib"
+	// LibFile is where Lib is found relative to a package.path root
+	LibFile = "kopl/lib.lua"
+)
+
+// Lib is the module of code shared by the helpers, the bridge plugin and
+// the busted helper of `kopl test`. DefineLib installs it for the helpers.
+//
+//go:embed koplbridge.koplugin/kopl/lib.lua
+var Lib string
+
+type Helper struct {
+	Name   string
+	Source string
+}
+
+var (
+	//go:embed keys.lua
+	keysSource string
+	//go:embed eval.lua
+	evalSource string
+	//go:embed staged.lua
+	stagedSource string
+	//go:embed stream.lua
+	streamSource string
+	//go:embed hello.lua
+	helloSource string
+	//go:embed spec.lua
+	specSource string
+)
+
+var (
+	// Keys lists the string keys of the table an expression resolves to,
+	// following the `__index` chain of its metatables.
+	// Args: expression.
+	Keys = Helper{Name: "keys", Source: keysSource}
+
+	// Eval runs a chunk and returns a JSON object with its status,
+	// return values serialized up to a depth, or a typed error.
+	// Args: code, depth.
+	Eval = Helper{Name: "eval", Source: evalSource}
+
+	// Staged runs a chunk uploaded to a file on the device and removes the file.
+	// Args: path.
+	Staged = Helper{Name: "staged", Source: stagedSource}
+
+	// Stream runs a chunk through an installed Eval helper in a coroutine,
+	// collecting its output to be polled while it runs.
+	// Args: op (start, poll or cancel), stream ID, Eval's ID, code, depth,
+	// seconds the code may run without printing (0 for no limit).
+	Stream = Helper{Name: "stream", Source: streamSource}
+
+	// Hello returns the protocol of the running bridge plugin, the version
+	// of the loaded repl.koplugin and the capabilities of the Lua runtime.
+	// Args: none.
+	Hello = Helper{Name: "hello", Source: helloSource}
+
+	// Spec runs busted-style spec files of a plugin and returns a JSON
+	// object with the result of every test, and the lines of the plugin
+	// that ran if coverage is "1".
+	// Args: plugin directory, spec files relative to it separated by newlines, coverage.
+	Spec = Helper{Name: "spec", Source: specSource}
+)
+
+// ID identifies the helper's current source on the device.
+func (h Helper) ID() string {
+	sum := sha256.Sum256([]byte(h.Source))
+	return h.Name + "@" + hex.EncodeToString(sum[:])[:12]
+}
+
+// Define returns a chunk that installs the helper and calls it with args.
+// Helpers using Lib need it installed with DefineLib first.
+func (h Helper) Define(args ...string) string {
+	return `local helpers = package.loaded["kopl.helpers"] or {}
+package.loaded["kopl.helpers"] = helpers
+helpers["` + h.ID() + `"] = function(...)
+` + h.Source + `
+end
+return helpers["` + h.ID() + `"](` + callArgs(args) + `)`
+}
+
+// DefineLib returns a chunk that installs Lib as LibModule, unless the
+// device already has this version of it.
+func DefineLib() string {
+	sum := sha256.Sum256([]byte(Lib))
+	id := hex.EncodeToString(sum[:])[:12]
+	return `local helpers = package.loaded["kopl.helpers"] or {}
+package.loaded["kopl.helpers"] = helpers
+if helpers.lib_id ~= "` + id + `" or not package.loaded["` + LibModule + `"] then
+package.loaded["` + LibModule + `"] = (function()
+` + Lib + `
+end)()
+helpers.lib_id = "` + id + `"
+end
+return "` + id + `"`
+}
+
+// Call returns a chunk that calls the installed helper with args.
+// The chunk returns Missing if the helper isn't installed,
+// e.g. because KOReader was restarted.
+func (h Helper) Call(args ...string) string {
+	return `local helpers = package.loaded["kopl.helpers"]
+local helper = helpers and helpers["` + h.ID() + `"]
+if not helper then return "` + Missing + `" end
+return helper(` + callArgs(args) + `)`
+}
+
+// callArgs is the calling chunk's environment followed by args quoted
+func callArgs(args []string) string {
+	quoted := []string{"getfenv(1)"}
+	for _, arg := range args {
+		quoted = append(quoted, Quote(arg))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// Quote turns s into a Lua long string literal.
+func Quote(s string) string {
+	level := ""
+	for strings.Contains(s, "]"+level+"]") || strings.HasSuffix(s, "]"+level) {
+		level += "="
+	}
+	// A newline right after the opening bracket is skipped by Lua
+	return "[" + level + "[\n" + s + "]" + level + "]"
+}
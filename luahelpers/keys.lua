@@ -0,0 +1,34 @@
+local env, expression = ...
+
+local getter = loadstring("return " .. expression)
+if not getter then
+    return ""
+end
+setfenv(getter, env)
+
+local ok, object = pcall(getter)
+if not ok then
+    return ""
+end
+
+local seen, keys = {}, {}
+for _ = 1, 16 do
+    if type(object) == "table" then
+        for key in pairs(object) do
+            if type(key) == "string" and not seen[key] then
+                seen[key] = true
+                keys[#keys + 1] = key
+            end
+        end
+    end
+
+    local mt = getmetatable(object)
+    local index = type(mt) == "table" and rawget(mt, "__index")
+    if type(index) ~= "table" then
+        break
+    end
+    object = index
+end
+
+table.sort(keys)
+return table.concat(keys, "\n")
@@ -0,0 +1,6 @@
+local _ = require("gettext")
+return {
+    name = "koplbridge",
+    fullname = _("kopl bridge"),
+    description = _([[Lets `kopl repl --bridge` evaluate Lua over SSH, without HTTP Inspector.]]),
+}
@@ -0,0 +1,88 @@
+--[[--
+Code shared by kopl's helpers, koplbridge.koplugin and the busted helper of
+`kopl test`, loaded as the "kopl.lib" module.
+
+Helpers get it installed by their definition chunk, the bridge plugin ships
+it, and `kopl test` puts it next to the stub modules.
+
+@module kopl.lib
+--]]--
+
+local M = {}
+
+--- Encodes a string as a JSON string literal.
+function M.encodeString(s)
+    return '"' .. s:gsub('[%c"\\]', function(c)
+        return string.format("\\u%04x", c:byte())
+    end) .. '"'
+end
+
+--- Encodes hits collected by collectLines as a JSON object of files
+-- to objects of line numbers to counts.
+function M.encodeHits(hits)
+    local files = {}
+    for file, lines in pairs(hits) do
+        local counts = {}
+        for line, count in pairs(lines) do
+            counts[#counts + 1] = string.format('"%d":%d', line, count)
+        end
+        files[#files + 1] = M.encodeString(file) .. ":{" .. table.concat(counts, ",") .. "}"
+    end
+    return "{" .. table.concat(files, ",") .. "}"
+end
+
+--- Sets a hook like debug.sethook until the returned function is called,
+-- which restores the previous hook and JIT state. The JIT compiler is off
+-- meanwhile, as compiled code doesn't call hooks.
+function M.hook(fn, mask, count)
+    -- Restored afterwards, KOReader or a debugger may have set them
+    local jit_on = jit and jit.status()
+    local previous_hook = { debug.gethook() }
+    if jit then
+        jit.off()
+        jit.flush()
+    end
+    debug.sethook(fn, mask, count)
+
+    return function()
+        if previous_hook[1] then
+            debug.sethook((table.unpack or unpack)(previous_hook))
+        else
+            debug.sethook()
+        end
+        if jit_on then
+            jit.on()
+        end
+    end
+end
+
+--- Starts counting how many times lines run, with a line hook.
+-- fileOf turns a chunk's source into the path to count its lines under,
+-- or nil for chunks not to count.
+-- Returns the hits by path and line, and a function stopping the
+-- collection, which restores the previous hook and JIT state.
+function M.collectLines(fileOf)
+    local hits = {}
+    -- Paths by chunk source, false for chunks not to count
+    local files = {}
+
+    local stop = M.hook(function(_, line)
+        local source = debug.getinfo(2, "S").source
+        local file = files[source]
+        if file == nil then
+            file = fileOf(source) or false
+            files[source] = file
+        end
+        if file then
+            local lines = hits[file]
+            if not lines then
+                lines = {}
+                hits[file] = lines
+            end
+            lines[line] = (lines[line] or 0) + 1
+        end
+    end, "l")
+    return hits, stop
+end
+
+return M
@@ -0,0 +1,122 @@
+--[[--
+Evaluates Lua chunks sent by `kopl repl --bridge`.
+
+Listens on a local port only. kopl reaches it through a connection
+forwarded over SSH. A request is the length of the chunk on its own line,
+followed by the chunk. The response is a JSON line in the same format
+as repl.koplugin's.
+
+@module koplugin.KoplBridge
+--]]--
+
+local UIManager = require("ui/uimanager")
+local WidgetContainer = require("ui/widget/container/widgetcontainer")
+local logger = require("logger")
+local socket = require("socket")
+
+-- Must match luahelpers.BridgePort in kopl
+local PORT = 8788
+-- Version of the request and response format.
+-- Must match luahelpers.BridgeProtocol in kopl
+local PROTOCOL = 1
+local POLL_INTERVAL = 0.2
+
+-- Plugins are instantiated again for every ReaderUI and FileManager,
+-- and main.lua may be loaded again too. The server lives across them.
+local state = package.loaded["koplbridge.state"] or {}
+package.loaded["koplbridge.state"] = state
+
+local encodeString = require("kopl.lib").encodeString
+
+local function evaluate(code)
+    local out = {}
+    local env = setmetatable({
+        ui = state.ui,
+        print = function(...)
+            local parts = {}
+            for i = 1, select("#", ...) do
+                parts[i] = tostring((select(i, ...)))
+            end
+            out[#out + 1] = table.concat(parts, "\t")
+        end,
+    }, { __index = _G })
+
+    local fn, err = loadstring("return " .. code, "=repl")
+    if not fn then
+        fn, err = loadstring(code, "=repl")
+    end
+    if not fn then
+        return "", out, err
+    end
+    setfenv(fn, env)
+
+    local ok, res = pcall(fn)
+    if not ok then
+        return "", out, tostring(res)
+    end
+    return tostring(res), out, ""
+end
+
+local function handle(client)
+    client:settimeout(5)
+    local length = tonumber(client:receive("*l"))
+    if not length then
+        return
+    end
+    local code = client:receive(length)
+    if not code then
+        return
+    end
+
+    local ret, out, err = evaluate(code)
+    local lines = {}
+    for i, line in ipairs(out) do
+        lines[i] = encodeString(line)
+    end
+    client:send(string.format(
+        '[{"ret":%s,"out":[%s],"error":%s}]\n',
+        encodeString(ret),
+        table.concat(lines, ","),
+        encodeString(err)
+    ))
+end
+
+local function poll()
+    local client = state.server:accept()
+    while client do
+        local ok, err = pcall(handle, client)
+        if not ok then
+            logger.warn("KoplBridge: request failed:", err)
+        end
+        client:close()
+        client = state.server:accept()
+    end
+    UIManager:scheduleIn(POLL_INTERVAL, poll)
+end
+
+local KoplBridge = WidgetContainer:extend{
+    name = "koplbridge",
+    is_doc_only = false,
+}
+
+function KoplBridge:init()
+    state.ui = self.ui
+    if state.server then
+        return
+    end
+
+    local server, err = socket.bind("127.0.0.1", PORT)
+    if not server then
+        logger.warn("KoplBridge: couldn't listen on port", PORT, err)
+        return
+    end
+    server:settimeout(0)
+    state.server = server
+    -- Only the code that started the server speaks for it, a newer main.lua
+    -- may be loaded while it runs
+    state.protocol = PROTOCOL
+    UIManager:scheduleIn(POLL_INTERVAL, poll)
+    logger.info("KoplBridge: listening on 127.0.0.1:" .. PORT)
+end
+
+return KoplBridge
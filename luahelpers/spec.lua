@@ -0,0 +1,429 @@
+local env, plugin_dir, files, coverage = ...
+
+-- A runner for the part of busted's API specs use most: describe, it,
+-- pending, the hooks and luassert-like assertions.
+
+local has_socket, socket = pcall(require, "socket")
+local function now()
+    if has_socket and type(socket) == "table" and socket.gettime then
+        return socket.gettime()
+    end
+    return os.clock()
+end
+
+local kopl = require("kopl.lib")
+local encodeString = kopl.encodeString
+
+-- Assertions
+
+-- Raised by failed assertions, to tell failures from errors
+local Failure = {}
+Failure.__index = Failure
+Failure.__tostring = function(failure)
+    return failure.message
+end
+
+local function fail(message, level)
+    local info = debug.getinfo(level + 1, "Sl")
+    if info and info.currentline and info.currentline > 0 then
+        local source = info.short_src or info.source:gsub("^@", "")
+        message = source .. ":" .. info.currentline .. ": " .. message
+    end
+    error(setmetatable({ message = message }, Failure), 0)
+end
+
+local function format(value)
+    if type(value) == "string" then
+        return string.format("(string) %q", value)
+    end
+    return "(" .. type(value) .. ") " .. tostring(value)
+end
+
+local function same(a, b)
+    if a == b then
+        return true
+    end
+    if type(a) ~= "table" or type(b) ~= "table" then
+        return false
+    end
+    for key, value in pairs(a) do
+        if not same(value, b[key]) then
+            return false
+        end
+    end
+    for key in pairs(b) do
+        if a[key] == nil then
+            return false
+        end
+    end
+    return true
+end
+
+-- Each returns whether the assertion holds and what was expected of it
+local checks = {}
+
+function checks.equal(expected, actual)
+    return expected == actual, "Expected objects to be equal.\nPassed in:\n" .. format(actual) .. "\nExpected:\n" .. format(expected)
+end
+checks.equals = checks.equal
+
+function checks.same(expected, actual)
+    return same(expected, actual), "Expected objects to be the same.\nPassed in:\n" .. format(actual) .. "\nExpected:\n" .. format(expected)
+end
+
+checks["true"] = function(value)
+    return value == true, "Expected true, got " .. format(value)
+end
+
+checks["false"] = function(value)
+    return value == false, "Expected false, got " .. format(value)
+end
+
+checks["nil"] = function(value)
+    return value == nil, "Expected nil, got " .. format(value)
+end
+
+function checks.truthy(value)
+    return value ~= nil and value ~= false, "Expected a truthy value, got " .. format(value)
+end
+
+function checks.falsy(value)
+    return value == nil or value == false, "Expected a falsy value, got " .. format(value)
+end
+
+function checks.near(expected, actual, tolerance)
+    return math.abs(expected - actual) <= tolerance,
+        "Expected " .. format(actual) .. " to be within " .. tolerance .. " of " .. format(expected)
+end
+
+function checks.matches(pattern, actual)
+    return type(actual) == "string" and actual:match(pattern) ~= nil,
+        "Expected " .. format(actual) .. " to match " .. format(pattern)
+end
+checks.match = checks.matches
+
+function checks.error(fn, expected)
+    local ok, err = pcall(fn)
+    if ok then
+        return false, "Expected an error"
+    end
+    if expected ~= nil and err ~= expected then
+        return false, "Expected error " .. format(expected) .. ", got " .. format(err)
+    end
+    return true, "Expected no error, got " .. format(err)
+end
+checks.errors = checks.error
+checks.has_error = checks.error
+
+for _, name in ipairs({ "string", "number", "table", "function", "boolean", "userdata" }) do
+    checks[name] = function(value)
+        return type(value) == name, "Expected a " .. name .. ", got " .. format(value)
+    end
+end
+
+-- Chained words like in assert.is_not.equal or assert.are_not_same
+local modifiers = { are = true, is = true, has = true, have = true, does = true, was = true, to = true }
+local negations = { ["not"] = true, no = true }
+
+local function makeAssert(negated)
+    return setmetatable({}, {
+        __call = function(_, value, message, ...)
+            if not value then
+                fail(message or "assertion failed!", 2)
+            end
+            return value, message, ...
+        end,
+        __index = function(_, key)
+            local words = {}
+            local negate = negated
+            for word in key:gmatch("[^_]+") do
+                if negations[word] then
+                    negate = not negate
+                elseif not modifiers[word] then
+                    words[#words + 1] = word
+                end
+            end
+            if #words == 0 then
+                return makeAssert(negate)
+            end
+            local check = checks[table.concat(words, "_")]
+            if not check then
+                return nil
+            end
+            return function(...)
+                local ok, message = check(...)
+                if ok == negate then
+                    if negate then
+                        message = "Expected the opposite of: " .. message
+                    end
+                    fail(message, 2)
+                end
+            end
+        end,
+    })
+end
+
+-- Collecting specs
+
+local results = {}
+
+local function record(file, names, status, message, elapsed)
+    results[#results + 1] = {
+        file = file,
+        suite = table.concat(names, " ", 1, #names - 1),
+        name = names[#names],
+        status = status,
+        message = message,
+        elapsed = elapsed,
+    }
+end
+
+local function newBlock(name, parent)
+    return {
+        name = name,
+        parent = parent,
+        children = {},
+        setup = {},
+        teardown = {},
+        before_each = {},
+        after_each = {},
+    }
+end
+
+local function names(node)
+    local list = {}
+    while node and node.parent do
+        table.insert(list, 1, node.name)
+        node = node.parent
+    end
+    return list
+end
+
+local function loadSpec(file)
+    local root = newBlock(file)
+    local current = root
+
+    local spec_env = setmetatable({}, { __index = env, __newindex = env })
+    function spec_env.describe(name, fn)
+        local block = newBlock(name, current)
+        table.insert(current.children, block)
+        local parent = current
+        current = block
+        fn()
+        current = parent
+    end
+    spec_env.context = spec_env.describe
+    spec_env.insulate = spec_env.describe
+    spec_env.expose = spec_env.describe
+    function spec_env.it(name, fn)
+        table.insert(current.children, { name = name, fn = fn, parent = current })
+    end
+    spec_env.spec = spec_env.it
+    spec_env.test = spec_env.it
+    function spec_env.pending(name)
+        table.insert(current.children, { name = name, parent = current })
+    end
+    for _, hook in ipairs({ "setup", "teardown", "before_each", "after_each" }) do
+        spec_env[hook] = function(fn)
+            table.insert(current[hook], fn)
+        end
+    end
+    spec_env.lazy_setup = spec_env.setup
+    spec_env.strict_setup = spec_env.setup
+    spec_env.lazy_teardown = spec_env.teardown
+    spec_env.strict_teardown = spec_env.teardown
+    spec_env.assert = makeAssert(false)
+
+    local path = plugin_dir .. "/" .. file
+    local source = io.open(path, "rb")
+    if not source then
+        return nil, "can't open " .. path
+    end
+    local code = source:read("*a")
+    source:close()
+    local fn, err = loadstring(code, "@" .. file)
+    if not fn then
+        return nil, err
+    end
+    setfenv(fn, spec_env)
+    local ok, load_err = xpcall(fn, debug.traceback)
+    if not ok then
+        return nil, load_err
+    end
+    return root
+end
+
+-- Running specs
+
+local function traceback(err)
+    if getmetatable(err) == Failure then
+        return err
+    end
+    local frames = {}
+    for frame in debug.traceback("", 2):gmatch("\n\t([^\n]*)") do
+        if frame:match("in function 'xpcall'") then
+            break
+        end
+        frames[#frames + 1] = frame
+    end
+    -- Frames below the specs and the plugin's files belong to this helper
+    while #frames > 0 and not frames[#frames]:match("%.lua:%d+:") do
+        frames[#frames] = nil
+    end
+    if #frames == 0 then
+        return tostring(err)
+    end
+    return tostring(err) .. "\nstack traceback:\n\t" .. table.concat(frames, "\n\t")
+end
+
+local function runHooks(hooks)
+    for _, hook in ipairs(hooks) do
+        hook()
+    end
+end
+
+local function runTest(file, test)
+    if not test.fn then
+        record(file, names(test), "pending")
+        return
+    end
+
+    local chain = {}
+    local block = test.parent
+    while block do
+        table.insert(chain, 1, block)
+        block = block.parent
+    end
+
+    local start = now()
+    local ok, err = xpcall(function()
+        for _, b in ipairs(chain) do
+            runHooks(b.before_each)
+        end
+        test.fn()
+    end, traceback)
+    for i = #chain, 1, -1 do
+        local hook_ok, hook_err = xpcall(function()
+            runHooks(chain[i].after_each)
+        end, traceback)
+        if ok and not hook_ok then
+            ok, err = hook_ok, hook_err
+        end
+    end
+    local elapsed = now() - start
+
+    if ok then
+        record(file, names(test), "success", nil, elapsed)
+    elseif getmetatable(err) == Failure then
+        record(file, names(test), "failure", err.message, elapsed)
+    else
+        record(file, names(test), "error", tostring(err), elapsed)
+    end
+end
+
+local function runBlock(file, block)
+    local ok, err = xpcall(function()
+        runHooks(block.setup)
+    end, traceback)
+    if not ok then
+        local block_names = names(block)
+        block_names[#block_names + 1] = "setup"
+        record(file, block_names, "error", tostring(err), 0)
+        return
+    end
+
+    for _, child in ipairs(block.children) do
+        if child.children then
+            runBlock(file, child)
+        else
+            runTest(file, child)
+        end
+    end
+
+    ok, err = xpcall(function()
+        runHooks(block.teardown)
+    end, traceback)
+    if not ok then
+        local block_names = names(block)
+        block_names[#block_names + 1] = "teardown"
+        record(file, block_names, "error", tostring(err), 0)
+    end
+end
+
+-- Where require finds a module's file, nil if it has none
+local function searchpath(name, path)
+    if package.searchpath then
+        return package.searchpath(name, path)
+    end
+    name = name:gsub("%.", "/")
+    for template in path:gmatch("[^;]+") do
+        local file = template:gsub("%?", name)
+        local f = io.open(file)
+        if f then
+            f:close()
+            return file
+        end
+    end
+end
+
+-- Specs require the plugin's modules first. The plugin's modules they load
+-- are unloaded afterwards, so that the next run sees changes. Others, like
+-- KOReader's singletons, are kept.
+local package_path = package.path
+package.path = plugin_dir .. "/?.lua;" .. plugin_dir .. "/?/init.lua;" .. package.path
+local loaded = {}
+for name in pairs(package.loaded) do
+    loaded[name] = true
+end
+
+-- Lines of the plugin's files that ran, by path relative to it
+local hits = {}
+local stopCollecting
+if coverage == "1" then
+    local prefix = "@" .. plugin_dir .. "/"
+    hits, stopCollecting = kopl.collectLines(function(source)
+        if source:sub(1, #prefix) == prefix then
+            return source:sub(#prefix + 1)
+        end
+    end)
+end
+
+for file in files:gmatch("[^\n]+") do
+    local root, err = loadSpec(file)
+    if root then
+        runBlock(file, root)
+    else
+        record(file, { file }, "error", tostring(err), 0)
+    end
+end
+
+if stopCollecting then
+    stopCollecting()
+end
+
+local plugin_prefix = plugin_dir .. "/"
+for name in pairs(package.loaded) do
+    if not loaded[name] then
+        local file = searchpath(name, package.path)
+        if file and file:sub(1, #plugin_prefix) == plugin_prefix then
+            package.loaded[name] = nil
+        end
+    end
+end
+package.path = package_path
+
+local parts = {}
+for i, result in ipairs(results) do
+    local fields = {
+        '"file":' .. encodeString(result.file),
+        '"suite":' .. encodeString(result.suite),
+        '"name":' .. encodeString(result.name),
+        '"status":' .. encodeString(result.status),
+        '"elapsed":' .. string.format("%.6f", result.elapsed or 0),
+    }
+    if result.message then
+        fields[#fields + 1] = '"message":' .. encodeString(result.message)
+    end
+    parts[i] = "{" .. table.concat(fields, ",") .. "}"
+end
+return '{"tests":[' .. table.concat(parts, ",") .. '],"coverage":' .. kopl.encodeHits(hits) .. "}"
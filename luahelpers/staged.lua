@@ -0,0 +1,19 @@
+local env, path = ...
+
+local file = io.open(path, "rb")
+if not file then
+    error("staged chunk " .. path .. " is missing", 0)
+end
+local code = file:read("*a")
+file:close()
+os.remove(path)
+
+local fn, err = loadstring("return " .. code, "=repl")
+if not fn then
+    fn, err = loadstring(code, "=repl")
+end
+if not fn then
+    error(err, 0)
+end
+setfenv(fn, env)
+return fn()
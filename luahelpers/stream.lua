@@ -0,0 +1,124 @@
+local env, op, id, eval_id, code, max_depth, timeout = ...
+
+local UIManager = require("ui/uimanager")
+
+local streams = package.loaded["kopl.streams"] or {}
+package.loaded["kopl.streams"] = streams
+
+local kopl = require("kopl.lib")
+local encodeString = kopl.encodeString
+
+local function encodeLines(lines)
+    local parts = {}
+    for i, line in ipairs(lines) do
+        parts[i] = encodeString(line)
+    end
+    return "[" .. table.concat(parts, ",") .. "]"
+end
+
+-- Hands over the output collected since the last poll
+local function respond(stream)
+    local out = stream.out
+    stream.out = {}
+    if stream.result and stream.holds == 0 then
+        streams[id] = nil
+        return '{"status":"done","out":' .. encodeLines(out) .. ',"result":' .. stream.result .. "}"
+    end
+    return '{"status":"running","out":' .. encodeLines(out) .. "}"
+end
+
+if op == "poll" then
+    local stream = streams[id]
+    if not stream then
+        return '{"status":"error","message":"no such stream"}'
+    end
+    return respond(stream)
+end
+
+if op == "cancel" then
+    local stream = streams[id]
+    if stream then
+        stream.cancelled = true
+        stream.holds = 0
+    end
+    return '{"status":"cancelled"}'
+end
+
+-- op == "start"
+local helpers = package.loaded["kopl.helpers"]
+local eval = helpers and helpers[eval_id]
+if not eval then
+    return '{"status":"missing"}'
+end
+
+local stream = { out = {}, holds = 0, timeout = tonumber(timeout) or 0 }
+streams[id] = stream
+
+local function checkCancelled()
+    if stream.cancelled then
+        error("interrupted", 0)
+    end
+end
+
+local stream_env = setmetatable({
+    print = function(...)
+        local parts = {}
+        for i = 1, select("#", ...) do
+            parts[i] = tostring((select(i, ...)))
+        end
+        stream.out[#stream.out + 1] = table.concat(parts, "\t")
+        checkCancelled()
+        -- Let UIManager serve the polls. Yielding fails harmlessly across C calls.
+        if coroutine.running() == stream.co then
+            pcall(coroutine.yield)
+            checkCancelled()
+        end
+    end,
+    -- Keeps the stream open until the returned function is called,
+    -- e.g. by a callback scheduled with UIManager.
+    hold = function()
+        stream.holds = stream.holds + 1
+        local released = false
+        return function()
+            if not released then
+                released = true
+                stream.holds = math.max(stream.holds - 1, 0)
+            end
+        end
+    end,
+}, { __index = env, __newindex = env })
+
+stream.co = coroutine.create(function()
+    return eval(stream_env, code, max_depth)
+end)
+
+local function step()
+    -- Cancel requests can't be served while the code holds the UI thread,
+    -- so code that doesn't print is stopped by its own deadline instead
+    local deadline = os.time() + stream.timeout
+    local unhook
+    if stream.timeout > 0 and debug and debug.sethook then
+        unhook = kopl.hook(function()
+            if coroutine.running() == stream.co and os.time() >= deadline then
+                error(string.format("timed out: ran for %ds without printing", stream.timeout), 0)
+            end
+        end, "", 10000)
+    end
+    local ok, res = coroutine.resume(stream.co)
+    if unhook then
+        unhook()
+    end
+
+    if coroutine.status(stream.co) ~= "dead" then
+        UIManager:nextTick(step)
+    elseif ok then
+        stream.result = res
+    else
+        stream.result = '{"status":"error","error":{"code":"runtime","message":'
+            .. encodeString(tostring(res))
+            .. "}}"
+    end
+end
+step()
+
+return respond(stream)
@@ -0,0 +1,40 @@
+-- Loaded by busted before the specs, with the stub modules on package.path
+
+local busted = require("busted")
+local LuaSettings = require("luasettings")
+
+G_reader_settings = LuaSettings:open("settings.reader.lua")
+G_defaults = LuaSettings:open("defaults.custom.lua")
+
+local function reset()
+    G_reader_settings:reset()
+    G_defaults:reset()
+    require("ui/uimanager"):reset()
+    require("dispatcher"):reset()
+    require("logger"):reset()
+end
+
+-- Every test starts with nothing shown, scheduled, registered or saved
+busted.subscribe({ "test", "start" }, function()
+    reset()
+    return nil, true
+end)
+
+-- Set by `kopl test --coverage` to where the lines that ran are written
+local coverage_path = os.getenv("KOPL_COVERAGE")
+if coverage_path then
+    local kopl = require("kopl.lib")
+    local hits, stopCollecting = kopl.collectLines(function(source)
+        if source:sub(1, 1) == "@" then
+            return source:sub(2)
+        end
+    end)
+
+    busted.subscribe({ "exit" }, function()
+        stopCollecting()
+        local out = assert(io.open(coverage_path, "w"))
+        out:write(kopl.encodeHits(hits))
+        out:close()
+        return nil, true
+    end)
+end
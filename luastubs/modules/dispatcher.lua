@@ -0,0 +1,43 @@
+--[[--
+Stand-in for KOReader's Dispatcher.
+
+Registered actions are kept in Dispatcher.actions, by name.
+]]
+
+local Dispatcher = {}
+
+-- Forgets the registered actions, done before every test
+function Dispatcher:reset()
+    self.actions = {}
+end
+
+Dispatcher:reset()
+
+function Dispatcher:init() end
+
+function Dispatcher:registerAction(name, value)
+    self.actions[name] = value
+    return true
+end
+
+function Dispatcher:removeAction(name)
+    self.actions[name] = nil
+    return true
+end
+
+-- Sends the events of the actions enabled in settings, like a gesture would
+function Dispatcher:execute(settings)
+    local Event = require("ui/event")
+    local UIManager = require("ui/uimanager")
+    for name, arg in pairs(settings) do
+        local action = self.actions[name]
+        if action and action.event then
+            if action.arg ~= nil then
+                arg = action.arg
+            end
+            UIManager:sendEvent(Event:new(action.event, arg))
+        end
+    end
+end
+
+return Dispatcher
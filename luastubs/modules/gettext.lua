@@ -0,0 +1,30 @@
+--[[--
+Stand-in for KOReader's gettext. Strings aren't translated.
+]]
+
+local GetText = {}
+
+setmetatable(GetText, {
+    __call = function(_, msgid)
+        return msgid
+    end,
+})
+
+function GetText.ngettext(msgid, msgid_plural, n)
+    if n == 1 then
+        return msgid
+    end
+    return msgid_plural
+end
+
+function GetText.pgettext(_, msgid)
+    return msgid
+end
+
+function GetText.npgettext(_, msgid, msgid_plural, n)
+    return GetText.ngettext(msgid, msgid_plural, n)
+end
+
+function GetText.changeLang() end
+
+return GetText
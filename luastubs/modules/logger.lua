@@ -0,0 +1,32 @@
+--[[--
+Stand-in for KOReader's logger.
+
+Messages aren't printed but kept in logger.messages, as
+{ level = "warn", text = "..." }, so that specs can check them.
+]]
+
+local logger = {}
+
+-- Forgets the messages, done before every test
+function logger:reset()
+    self.messages = {}
+end
+
+logger:reset()
+
+local function log(level)
+    return function(...)
+        local parts = {}
+        for i = 1, select("#", ...) do
+            parts[i] = tostring((select(i, ...)))
+        end
+        table.insert(logger.messages, { level = level, text = table.concat(parts, " ") })
+    end
+end
+
+logger.dbg = log("dbg")
+logger.info = log("info")
+logger.warn = log("warn")
+logger.err = log("err")
+
+return logger
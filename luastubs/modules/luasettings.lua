@@ -0,0 +1,109 @@
+--[[--
+Stand-in for KOReader's LuaSettings, kept in memory.
+
+G_reader_settings and G_defaults are instances of it.
+]]
+
+local LuaSettings = {}
+
+function LuaSettings:open(file_path)
+    local o = { file = file_path, data = {} }
+    setmetatable(o, self)
+    self.__index = self
+    return o
+end
+
+-- Forgets all settings, done before every test for the globals
+function LuaSettings:reset()
+    self.data = {}
+end
+
+function LuaSettings:readSetting(key, default)
+    if self.data[key] == nil and default ~= nil then
+        self.data[key] = default
+    end
+    return self.data[key]
+end
+
+function LuaSettings:saveSetting(key, value)
+    self.data[key] = value
+    return self
+end
+
+function LuaSettings:delSetting(key)
+    self.data[key] = nil
+    return self
+end
+
+function LuaSettings:has(key)
+    return self.data[key] ~= nil
+end
+
+function LuaSettings:hasNot(key)
+    return self.data[key] == nil
+end
+
+function LuaSettings:isTrue(key)
+    return self.data[key] == true
+end
+
+function LuaSettings:isFalse(key)
+    return self.data[key] == false
+end
+
+function LuaSettings:nilOrTrue(key)
+    return self.data[key] ~= false
+end
+
+function LuaSettings:nilOrFalse(key)
+    return self.data[key] ~= true
+end
+
+function LuaSettings:makeTrue(key)
+    self.data[key] = true
+    return self
+end
+
+function LuaSettings:makeFalse(key)
+    self.data[key] = false
+    return self
+end
+
+function LuaSettings:toggle(key)
+    self.data[key] = not self.data[key]
+    return self
+end
+
+function LuaSettings:flipNilOrTrue(key)
+    if self:nilOrTrue(key) then
+        self.data[key] = false
+    else
+        self.data[key] = nil
+    end
+    return self
+end
+
+function LuaSettings:flipNilOrFalse(key)
+    if self:nilOrFalse(key) then
+        self.data[key] = true
+    else
+        self.data[key] = nil
+    end
+    return self
+end
+
+function LuaSettings:child(key)
+    local child = LuaSettings:open(self.file)
+    child.data = self:readSetting(key, {})
+    return child
+end
+
+function LuaSettings:flush()
+    return self
+end
+
+function LuaSettings:close() end
+
+function LuaSettings:purge() end
+
+return LuaSettings
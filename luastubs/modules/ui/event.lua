@@ -0,0 +1,18 @@
+--[[--
+Stand-in for KOReader's Event.
+]]
+
+local Event = {}
+
+function Event:new(name, ...)
+    local o = {
+        handler = "on" .. name,
+        args = { ... },
+        argc = select("#", ...),
+    }
+    setmetatable(o, self)
+    self.__index = self
+    return o
+end
+
+return Event
@@ -0,0 +1,132 @@
+--[[--
+Stand-in for KOReader's UIManager.
+
+Nothing is drawn and time doesn't pass by itself. Shown widgets, scheduled
+tasks and events are recorded, so that specs can inspect them, and
+`UIManager:advance` runs the tasks that are due:
+
+    plugin:onSync()
+    assert.are.equal(1, #UIManager.shown)
+    UIManager:advance(5)
+    assert.are.equal(0, #UIManager.shown)
+]]
+
+-- For Lua 5.2+, where busted might be running
+local unpack = unpack or table.unpack
+
+local Event = require("ui/event")
+
+local UIManager = {}
+
+-- Forgets everything, done before every test
+function UIManager:reset()
+    -- Widgets shown and not closed yet, oldest first
+    self.shown = {}
+    -- Tasks not run yet, as { time = ..., action = ..., args = {...}, argc = ... }
+    self.scheduled = {}
+    -- Events sent and broadcast, oldest first
+    self.events = {}
+    -- Seconds passed since the test started
+    self.clock = 0
+end
+
+UIManager:reset()
+
+function UIManager:show(widget)
+    table.insert(self.shown, widget)
+    if widget.handleEvent then
+        widget:handleEvent(Event:new("Show"))
+    end
+end
+
+function UIManager:close(widget)
+    for i, shown in ipairs(self.shown) do
+        if shown == widget then
+            table.remove(self.shown, i)
+            if widget.handleEvent then
+                widget:handleEvent(Event:new("CloseWidget"))
+            end
+            if widget.close_callback then
+                widget.close_callback()
+            end
+            return
+        end
+    end
+end
+
+-- Returns the topmost shown widget
+function UIManager:getTopmostVisibleWidget()
+    return self.shown[#self.shown]
+end
+
+function UIManager:scheduleIn(seconds, action, ...)
+    table.insert(self.scheduled, {
+        time = self.clock + seconds,
+        action = action,
+        args = { ... },
+        argc = select("#", ...),
+    })
+end
+
+function UIManager:nextTick(action, ...)
+    return self:scheduleIn(0, action, ...)
+end
+
+function UIManager:tickAfterNext(action, ...)
+    return self:scheduleIn(0, action, ...)
+end
+
+function UIManager:unschedule(action)
+    for i = #self.scheduled, 1, -1 do
+        if self.scheduled[i].action == action then
+            table.remove(self.scheduled, i)
+        end
+    end
+end
+
+-- Moves the clock forward and runs the tasks that are due by then, in order.
+-- Tasks they schedule run too if they're due.
+function UIManager:advance(seconds)
+    self.clock = self.clock + (seconds or 0)
+    while true do
+        local next_index
+        for i, task in ipairs(self.scheduled) do
+            if task.time <= self.clock and (not next_index or task.time < self.scheduled[next_index].time) then
+                next_index = i
+            end
+        end
+        if not next_index then
+            return
+        end
+        local task = table.remove(self.scheduled, next_index)
+        task.action(unpack(task.args, 1, task.argc))
+    end
+end
+
+function UIManager:sendEvent(event)
+    table.insert(self.events, event)
+    local widget = self:getTopmostVisibleWidget()
+    if widget and widget.handleEvent then
+        return widget:handleEvent(event)
+    end
+end
+
+function UIManager:broadcastEvent(event)
+    table.insert(self.events, event)
+    for i = #self.shown, 1, -1 do
+        local widget = self.shown[i]
+        if widget.handleEvent then
+            widget:handleEvent(event)
+        end
+    end
+end
+
+function UIManager:setDirty() end
+
+function UIManager:forceRePaint() end
+
+function UIManager:preventStandby() end
+
+function UIManager:allowStandby() end
+
+return UIManager
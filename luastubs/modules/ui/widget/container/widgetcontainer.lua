@@ -0,0 +1,19 @@
+--[[--
+Stand-in for KOReader's WidgetContainer, which plugins extend.
+]]
+
+local Widget = require("ui/widget/widget")
+
+local WidgetContainer = Widget:extend{}
+
+-- Propagates the event to the children first, like KOReader does
+function WidgetContainer:handleEvent(event)
+    for _, widget in ipairs(self) do
+        if widget:handleEvent(event) then
+            return true
+        end
+    end
+    return Widget.handleEvent(self, event)
+end
+
+return WidgetContainer
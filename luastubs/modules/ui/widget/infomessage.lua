@@ -0,0 +1,34 @@
+--[[--
+Stand-in for KOReader's InfoMessage.
+
+Specs can check what a plugin showed through UIManager.shown:
+
+    UIManager:show(InfoMessage:new{ text = _("Done") })
+    assert.are.equal("Done", UIManager.shown[1].text)
+]]
+
+local Widget = require("ui/widget/widget")
+
+local InfoMessage = Widget:extend{
+    text = "",
+    -- Seconds after which the message closes itself, nil to keep it
+    timeout = nil,
+}
+
+function InfoMessage:onShow()
+    if self.timeout then
+        local UIManager = require("ui/uimanager")
+        UIManager:scheduleIn(self.timeout, function()
+            UIManager:close(self)
+        end)
+    end
+    return true
+end
+
+function InfoMessage:onCloseWidget()
+    if self.dismiss_callback then
+        self.dismiss_callback()
+    end
+end
+
+return InfoMessage
@@ -0,0 +1,36 @@
+--[[--
+Stand-in for KOReader's Widget, the base of all widgets.
+
+Widgets are tables of their fields: nothing is laid out or painted.
+]]
+
+-- For Lua 5.2+, where busted might be running
+local unpack = unpack or table.unpack
+
+local Widget = {}
+
+function Widget:extend(subclass_prototype)
+    local o = subclass_prototype or {}
+    setmetatable(o, self)
+    self.__index = self
+    return o
+end
+
+function Widget:new(o)
+    o = self:extend(o)
+    if o.init then
+        o:init()
+    end
+    return o
+end
+
+-- Calls the widget's handler of the event, like onClose for Event:new("Close")
+function Widget:handleEvent(event)
+    if self[event.handler] then
+        return self[event.handler](self, unpack(event.args, 1, event.argc))
+    end
+end
+
+function Widget:free() end
+
+return Widget
@@ -0,0 +1,52 @@
+// Package luastubs contains stand-ins for the KOReader modules plugins use
+// most, so that plugin logic can be tested on a computer, where the real
+// ones can't be loaded.
+package luastubs
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/Consoleaf/kopl/luahelpers"
+)
+
+// Helper is loaded by busted before the specs. It sets up KOReader's
+// globals and resets the stubs before every test.
+const Helper = "helper.lua"
+
+// ModulesDir contains the stub modules, laid out like koreader/frontend
+const ModulesDir = "modules"
+
+//go:embed helper.lua modules
+var files embed.FS
+
+// Extract writes the helper and the stub modules into dir, and kopl's
+// shared Lua module, which the helper uses, next to them.
+func Extract(dir string) error {
+	err := fs.WalkDir(files, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(path))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		data, err := files.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0o644)
+	})
+	if err != nil {
+		return err
+	}
+
+	lib := filepath.Join(dir, ModulesDir, filepath.FromSlash(luahelpers.LibFile))
+	err = os.MkdirAll(filepath.Dir(lib), 0o755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(lib, []byte(luahelpers.Lib), 0o644)
+}
@@ -0,0 +1,304 @@
+// Package luasyntax contains a minimal Lua lexer and a parser for table
+// literals, good enough to read plugin metadata and spot common mistakes.
+package luasyntax
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Kind int
+
+const (
+	EOF Kind = iota
+	Name
+	Keyword
+	String
+	Number
+	Symbol
+)
+
+type Token struct {
+	Kind  Kind
+	Value string
+	// Line and Column are 1-based
+	Line   int
+	Column int
+}
+
+func (t Token) Is(kind Kind, value string) bool {
+	return t.Kind == kind && t.Value == value
+}
+
+func (t Token) String() string {
+	if t.Kind == EOF {
+		return "<eof>"
+	}
+	return t.Value
+}
+
+var keywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "false": true, "for": true, "function": true, "goto": true,
+	"if": true, "in": true, "local": true, "nil": true, "not": true,
+	"or": true, "repeat": true, "return": true, "then": true, "true": true,
+	"until": true, "while": true,
+}
+
+// Longest first, so that "..." wins over ".." and "."
+var symbols = []string{
+	"...", "..", "==", "~=", "<=", ">=", "::", "//", "<<", ">>",
+	"+", "-", "*", "/", "%", "^", "#", "&", "~", "|", "<", ">", "=",
+	"(", ")", "{", "}", "[", "]", ";", ":", ",", ".",
+}
+
+type lexer struct {
+	src    string
+	pos    int
+	line   int
+	column int
+	tokens []Token
+}
+
+// Tokenize splits Lua source into tokens. Comments and whitespace are dropped.
+// The last token is always EOF.
+func Tokenize(src string) ([]Token, error) {
+	l := &lexer{src: src, line: 1, column: 1}
+	for {
+		l.skipSpaceAndComments()
+		if l.pos >= len(l.src) {
+			l.tokens = append(l.tokens, Token{Kind: EOF, Line: l.line, Column: l.column})
+			return l.tokens, nil
+		}
+		err := l.next()
+		if err != nil {
+			return l.tokens, err
+		}
+	}
+}
+
+// SyntaxError is an error at a position of the source.
+type SyntaxError struct {
+	// Line and Column are 1-based
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+func (l *lexer) errorf(format string, args ...any) error {
+	return &SyntaxError{Line: l.line, Column: l.column, Message: fmt.Sprintf(format, args...)}
+}
+
+func (l *lexer) advance(n int) {
+	for i := 0; i < n && l.pos < len(l.src); i++ {
+		if l.src[l.pos] == '\n' {
+			l.line++
+			l.column = 1
+		} else {
+			l.column++
+		}
+		l.pos++
+	}
+}
+
+func (l *lexer) peek(offset int) byte {
+	if l.pos+offset >= len(l.src) {
+		return 0
+	}
+	return l.src[l.pos+offset]
+}
+
+func (l *lexer) skipSpaceAndComments() {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == '\v':
+			l.advance(1)
+		case c == '-' && l.peek(1) == '-':
+			l.advance(2)
+			if level := l.longBracketLevel(); level >= 0 {
+				_, _ = l.readLongBracket(level)
+				continue
+			}
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.advance(1)
+			}
+		case c == '#' && l.pos == 0 && l.peek(1) == '!':
+			// Shebang
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.advance(1)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) next() error {
+	line, column := l.line, l.column
+	emit := func(kind Kind, value string) {
+		l.tokens = append(l.tokens, Token{Kind: kind, Value: value, Line: line, Column: column})
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case isNameStart(c):
+		start := l.pos
+		for l.pos < len(l.src) && isNameChar(l.src[l.pos]) {
+			l.advance(1)
+		}
+		word := l.src[start:l.pos]
+		if keywords[word] {
+			emit(Keyword, word)
+		} else {
+			emit(Name, word)
+		}
+	case isDigit(c) || (c == '.' && isDigit(l.peek(1))):
+		start := l.pos
+		exponent := "eE"
+		if c == '0' && (l.peek(1) == 'x' || l.peek(1) == 'X') {
+			exponent = "pP"
+		}
+		for l.pos < len(l.src) {
+			c := l.src[l.pos]
+			signedExponent := (c == '-' || c == '+') && strings.IndexByte(exponent, l.src[l.pos-1]) >= 0
+			if !isNameChar(c) && c != '.' && !signedExponent {
+				break
+			}
+			l.advance(1)
+		}
+		emit(Number, l.src[start:l.pos])
+	case c == '"' || c == '\'':
+		value, err := l.readQuoted(c)
+		if err != nil {
+			return err
+		}
+		emit(String, value)
+	case c == '[' && l.longBracketLevel() >= 0:
+		value, err := l.readLongBracket(l.longBracketLevel())
+		if err != nil {
+			return err
+		}
+		emit(String, value)
+	default:
+		for _, symbol := range symbols {
+			if strings.HasPrefix(l.src[l.pos:], symbol) {
+				l.advance(len(symbol))
+				emit(Symbol, symbol)
+				return nil
+			}
+		}
+		return l.errorf("unexpected symbol %q", c)
+	}
+	return nil
+}
+
+// longBracketLevel returns the level of a long bracket starting at the
+// current position ("[[" is 0, "[==[" is 2), or -1 if there is none.
+func (l *lexer) longBracketLevel() int {
+	if l.peek(0) != '[' {
+		return -1
+	}
+	level := 0
+	for l.peek(level+1) == '=' {
+		level++
+	}
+	if l.peek(level+1) != '[' {
+		return -1
+	}
+	return level
+}
+
+func (l *lexer) readLongBracket(level int) (string, error) {
+	l.advance(level + 2)
+	// A newline right after the opening bracket is skipped
+	if l.peek(0) == '\r' {
+		l.advance(1)
+	}
+	if l.peek(0) == '\n' {
+		l.advance(1)
+	}
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(l.src[l.pos:], closing)
+	if end < 0 {
+		return "", l.errorf("unfinished long string or comment")
+	}
+	value := l.src[l.pos : l.pos+end]
+	l.advance(end + len(closing))
+	return value, nil
+}
+
+func (l *lexer) readQuoted(quote byte) (string, error) {
+	var buf strings.Builder
+	l.advance(1)
+	for {
+		if l.pos >= len(l.src) || l.src[l.pos] == '\n' {
+			return "", l.errorf("unfinished string")
+		}
+		c := l.src[l.pos]
+		if c == quote {
+			l.advance(1)
+			return buf.String(), nil
+		}
+		if c != '\\' {
+			buf.WriteByte(c)
+			l.advance(1)
+			continue
+		}
+
+		l.advance(1)
+		escaped := l.peek(0)
+		switch escaped {
+		case 'n':
+			buf.WriteByte('\n')
+		case 't':
+			buf.WriteByte('\t')
+		case 'r':
+			buf.WriteByte('\r')
+		case 'a':
+			buf.WriteByte('\a')
+		case 'b':
+			buf.WriteByte('\b')
+		case 'f':
+			buf.WriteByte('\f')
+		case 'v':
+			buf.WriteByte('\v')
+		case '\n':
+			buf.WriteByte('\n')
+		case 'z':
+			l.advance(1)
+			for l.pos < len(l.src) && strings.IndexByte(" \t\r\n", l.src[l.pos]) >= 0 {
+				l.advance(1)
+			}
+			continue
+		default:
+			if isDigit(escaped) {
+				code := 0
+				for i := 0; i < 3 && isDigit(l.peek(0)); i++ {
+					code = code*10 + int(l.peek(0)-'0')
+					l.advance(1)
+				}
+				buf.WriteByte(byte(code))
+				continue
+			}
+			buf.WriteByte(escaped)
+		}
+		l.advance(1)
+	}
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
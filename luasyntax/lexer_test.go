@@ -0,0 +1,110 @@
+package luasyntax
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []Token
+	}{
+		{
+			"names and keywords",
+			"local x = nil",
+			[]Token{
+				{Keyword, "local", 1, 1},
+				{Name, "x", 1, 7},
+				{Symbol, "=", 1, 9},
+				{Keyword, "nil", 1, 11},
+			},
+		},
+		{
+			"longest symbol wins",
+			"a ... b .. c.d",
+			[]Token{
+				{Name, "a", 1, 1},
+				{Symbol, "...", 1, 3},
+				{Name, "b", 1, 7},
+				{Symbol, "..", 1, 9},
+				{Name, "c", 1, 12},
+				{Symbol, ".", 1, 13},
+				{Name, "d", 1, 14},
+			},
+		},
+		{
+			"numbers",
+			"1 0x1F 1e-3 .5 0x1p+4",
+			[]Token{
+				{Number, "1", 1, 1},
+				{Number, "0x1F", 1, 3},
+				{Number, "1e-3", 1, 8},
+				{Number, ".5", 1, 13},
+				{Number, "0x1p+4", 1, 16},
+			},
+		},
+		{
+			"comments and shebang",
+			"#!/usr/bin/lua\n-- line\n--[==[\nlong\n]==] x",
+			[]Token{
+				{Name, "x", 5, 6},
+			},
+		},
+		{
+			"quoted strings",
+			`"a\tb" 'it\'s' "\65\z
+			    B"`,
+			[]Token{
+				{String, "a\tb", 1, 1},
+				{String, "it's", 1, 8},
+				{String, "AB", 1, 16},
+			},
+		},
+		{
+			"long strings",
+			"[[\nfirst]] [=[a]]b]=]",
+			[]Token{
+				{String, "first", 1, 1},
+				{String, "a]]b", 2, 9},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokens, err := Tokenize(test.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := tokens[len(tokens)-1]
+			if last.Kind != EOF {
+				t.Fatalf("last token is %v, want EOF", last)
+			}
+			if got := tokens[:len(tokens)-1]; !slices.Equal(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"unfinished string", "x = 'abc\n'", "1:9: unfinished string"},
+		{"unfinished long string", "x = [[abc", "1:7: unfinished long string or comment"},
+		{"unexpected symbol", "x = @", "1:5: unexpected symbol '@'"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Tokenize(test.src)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package luasyntax
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Table is a parsed table constructor.
+type Table struct {
+	Fields []Field
+	Line   int
+}
+
+type Field struct {
+	// Key is a string for `name = v` and `["name"] = v`,
+	// the 1-based position for list items and nil for other keys.
+	Key any
+	// Value is a string, float64, bool, nil, *Table or Expr.
+	// Strings wrapped in gettext's `_()` are unwrapped.
+	Value  any
+	Line   int
+	Column int
+}
+
+// Expr is a value that isn't a literal, kept as its tokens.
+type Expr struct {
+	Tokens []Token
+}
+
+// Get returns the field with a string key.
+func (t *Table) Get(key string) (Field, bool) {
+	for _, field := range t.Fields {
+		if field.Key == key {
+			return field, true
+		}
+	}
+	return Field{}, false
+}
+
+// String returns the value of a string field, or "" if it's missing or not a string.
+func (t *Table) String(key string) string {
+	field, ok := t.Get(key)
+	if !ok {
+		return ""
+	}
+	value, _ := field.Value.(string)
+	return value
+}
+
+// Table returns the value of a nested table field, or nil.
+func (t *Table) Table(key string) *Table {
+	field, ok := t.Get(key)
+	if !ok {
+		return nil
+	}
+	value, _ := field.Value.(*Table)
+	return value
+}
+
+// Strings returns the string values of the table's list items.
+func (t *Table) Strings() []string {
+	var res []string
+	for _, field := range t.Fields {
+		if _, ok := field.Key.(int); !ok {
+			continue
+		}
+		if value, ok := field.Value.(string); ok {
+			res = append(res, value)
+		}
+	}
+	return res
+}
+
+// ParseReturnedTable parses the table constructor returned by a chunk,
+// like the one in `_meta.lua`.
+func ParseReturnedTable(src string) (*Table, error) {
+	tokens, err := Tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	returnAt := TopLevelReturn(tokens)
+	if returnAt < 0 || !tokens[returnAt+1].Is(Symbol, "{") {
+		return nil, fmt.Errorf("chunk doesn't return a table constructor")
+	}
+
+	table, _, err := ParseTable(tokens, returnAt+1)
+	return table, err
+}
+
+// ParseAssignedTable parses the table constructor assigned to the global
+// name at the top level of a chunk, like `build = { ... }` in a rockspec.
+// Returns nil if the chunk has no such assignment.
+func ParseAssignedTable(src string, name string) (*Table, error) {
+	tokens, err := Tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	depth := 0
+	for i, token := range tokens {
+		switch {
+		case token.Is(Symbol, "{"), token.Is(Symbol, "("), token.Is(Symbol, "["),
+			token.Is(Keyword, "function"), token.Is(Keyword, "do"), token.Is(Keyword, "then"), token.Is(Keyword, "repeat"):
+			depth++
+		case token.Is(Symbol, "}"), token.Is(Symbol, ")"), token.Is(Symbol, "]"),
+			token.Is(Keyword, "end"), token.Is(Keyword, "until"), token.Is(Keyword, "elseif"):
+			depth--
+		case depth == 0 && token.Is(Name, name) && tokens[i+1].Is(Symbol, "=") && tokens[i+2].Is(Symbol, "{"):
+			// Not a field of another table or a local
+			if i > 0 && (tokens[i-1].Is(Symbol, ".") || tokens[i-1].Is(Keyword, "local")) {
+				continue
+			}
+			table, _, err := ParseTable(tokens, i+2)
+			return table, err
+		}
+	}
+	return nil, nil
+}
+
+// TopLevelReturn returns the index of the chunk's own return statement,
+// which is the last top-level one, or -1 if it has none.
+func TopLevelReturn(tokens []Token) int {
+	depth := 0
+	returnAt := -1
+	for i, token := range tokens {
+		switch {
+		case token.Is(Keyword, "function"), token.Is(Keyword, "do"), token.Is(Keyword, "then"), token.Is(Keyword, "repeat"):
+			depth++
+		case token.Is(Keyword, "end"), token.Is(Keyword, "until"), token.Is(Keyword, "elseif"):
+			// `elseif` is followed by another `then`
+			depth--
+		case token.Is(Keyword, "return") && depth == 0:
+			returnAt = i
+		}
+	}
+	return returnAt
+}
+
+// ParseTable parses the table constructor starting at tokens[start],
+// returning the index of the token following it.
+func ParseTable(tokens []Token, start int) (*Table, int, error) {
+	open := tokens[start]
+	if !open.Is(Symbol, "{") {
+		return nil, start, errorAt(open, "expected '{', got '%s'", open)
+	}
+
+	table := &Table{Line: open.Line}
+	position := 0
+	i := start + 1
+	for {
+		token := tokens[i]
+		if token.Is(Symbol, "}") {
+			return table, i + 1, nil
+		}
+		if token.Kind == EOF {
+			return nil, i, errorAt(token, "unfinished table constructor")
+		}
+
+		field := Field{Line: token.Line, Column: token.Column}
+		switch {
+		case token.Kind == Name && tokens[i+1].Is(Symbol, "="):
+			field.Key = token.Value
+			i += 2
+		case token.Is(Symbol, "["):
+			keyEnd := skipExpr(tokens, i+1, "]")
+			if tokens[keyEnd].Kind == EOF || !tokens[keyEnd+1].Is(Symbol, "=") {
+				return nil, keyEnd, errorAt(tokens[keyEnd], "malformed table key")
+			}
+			if keyEnd == i+2 && tokens[i+1].Kind == String {
+				field.Key = tokens[i+1].Value
+			}
+			i = keyEnd + 2
+		default:
+			position++
+			field.Key = position
+		}
+
+		var err error
+		field.Value, i, err = parseValue(tokens, i)
+		if err != nil {
+			return nil, i, err
+		}
+		table.Fields = append(table.Fields, field)
+
+		if tokens[i].Is(Symbol, ",") || tokens[i].Is(Symbol, ";") {
+			i++
+		} else if !tokens[i].Is(Symbol, "}") {
+			return nil, i, errorAt(tokens[i], "expected '}' near '%s'", tokens[i])
+		}
+	}
+}
+
+func parseValue(tokens []Token, start int) (any, int, error) {
+	var value any
+	next := start + 1
+
+	token := tokens[start]
+	switch {
+	case token.Kind == String:
+		value = token.Value
+	case token.Kind == Number:
+		number, err := parseNumber(token.Value)
+		if err != nil {
+			return nil, start, errorAt(token, "malformed number '%s'", token)
+		}
+		value = number
+	case token.Is(Keyword, "true"):
+		value = true
+	case token.Is(Keyword, "false"):
+		value = false
+	case token.Is(Keyword, "nil"):
+		value = nil
+	case token.Is(Symbol, "{"):
+		table, end, err := ParseTable(tokens, start)
+		if err != nil {
+			return nil, end, err
+		}
+		value, next = table, end
+	case token.Is(Name, "_") && tokens[start+1].Kind == String:
+		value, next = tokens[start+1].Value, start+2
+	case token.Is(Name, "_") && tokens[start+1].Is(Symbol, "(") &&
+		tokens[start+2].Kind == String && tokens[start+3].Is(Symbol, ")"):
+		value, next = tokens[start+2].Value, start+4
+	default:
+		next = start
+	}
+
+	if isFieldEnd(tokens[next]) && next > start {
+		return value, next, nil
+	}
+
+	end := skipExpr(tokens, start, "")
+	if tokens[end].Kind == EOF {
+		return nil, end, errorAt(tokens[end], "unfinished table constructor")
+	}
+	return Expr{Tokens: tokens[start:end]}, end, nil
+}
+
+// skipExpr returns the index of the first token at the same nesting level
+// that ends a table field or, if closing is given, is that symbol.
+func skipExpr(tokens []Token, start int, closing string) int {
+	depth := 0
+	for i := start; i < len(tokens); i++ {
+		token := tokens[i]
+		if token.Kind == EOF {
+			return i
+		}
+		if depth == 0 {
+			if closing != "" && token.Is(Symbol, closing) {
+				return i
+			}
+			if closing == "" && isFieldEnd(token) {
+				return i
+			}
+		}
+		switch {
+		case token.Is(Symbol, "("), token.Is(Symbol, "{"), token.Is(Symbol, "["),
+			token.Is(Keyword, "function"), token.Is(Keyword, "then"), token.Is(Keyword, "do"),
+			token.Is(Keyword, "repeat"):
+			depth++
+		case token.Is(Symbol, ")"), token.Is(Symbol, "}"), token.Is(Symbol, "]"),
+			token.Is(Keyword, "end"), token.Is(Keyword, "until"), token.Is(Keyword, "elseif"):
+			depth--
+		}
+	}
+	return len(tokens) - 1
+}
+
+func isFieldEnd(token Token) bool {
+	return token.Is(Symbol, ",") || token.Is(Symbol, ";") || token.Is(Symbol, "}")
+}
+
+func parseNumber(text string) (float64, error) {
+	number, err := strconv.ParseFloat(text, 64)
+	if err == nil {
+		return number, nil
+	}
+	integer, err := strconv.ParseInt(text, 0, 64)
+	return float64(integer), err
+}
+
+func errorAt(token Token, format string, args ...any) error {
+	return &SyntaxError{Line: token.Line, Column: token.Column, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,159 @@
+package luasyntax
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseReturnedTable(t *testing.T) {
+	src := strings.Join([]string{
+		`local _ = require("gettext")`,
+		`return {`,
+		`    name = "hello",`,
+		`    fullname = _("Hello"),`,
+		`    description = _"Says hello",`,
+		`    ["version"] = "1.0",`,
+		`    enabled = true,`,
+		`    priority = 0x10,`,
+		`    tags = { "a", "b"; 3 },`,
+		`    handler = function(x) return { x } end,`,
+		`    [1 + 1] = nil,`,
+		`}`,
+	}, "\n")
+
+	table, err := ParseReturnedTable(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Line != 2 {
+		t.Errorf("table line is %d, want 2", table.Line)
+	}
+
+	for key, want := range map[string]string{
+		"name":        "hello",
+		"fullname":    "Hello",
+		"description": "Says hello",
+		"version":     "1.0",
+		"missing":     "",
+		"enabled":     "",
+	} {
+		if got := table.String(key); got != want {
+			t.Errorf("String(%q) = %q, want %q", key, got, want)
+		}
+	}
+
+	if field, _ := table.Get("enabled"); field.Value != true {
+		t.Errorf("enabled = %v, want true", field.Value)
+	}
+	if field, _ := table.Get("priority"); field.Value != float64(16) {
+		t.Errorf("priority = %v, want 16", field.Value)
+	}
+	if field, _ := table.Get("fullname"); field.Line != 4 || field.Column != 5 {
+		t.Errorf("fullname is at %d:%d, want 4:5", field.Line, field.Column)
+	}
+
+	if got := table.Table("tags").Strings(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("tags = %v, want [a b]", got)
+	}
+	if table.Table("name") != nil {
+		t.Errorf("Table(\"name\") isn't nil")
+	}
+
+	field, _ := table.Get("handler")
+	expr, ok := field.Value.(Expr)
+	if !ok {
+		t.Fatalf("handler = %#v, want an Expr", field.Value)
+	}
+	if first, last := expr.Tokens[0], expr.Tokens[len(expr.Tokens)-1]; !first.Is(Keyword, "function") || !last.Is(Keyword, "end") {
+		t.Errorf("handler spans %v to %v, want function to end", first, last)
+	}
+
+	computed := table.Fields[len(table.Fields)-1]
+	if computed.Key != nil || computed.Value != nil {
+		t.Errorf("computed key field = %#v, want nil key and value", computed)
+	}
+}
+
+func TestParseReturnedTableErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"no return", "local t = {}", "chunk doesn't return a table constructor"},
+		{"returns a name", "local t = {}\nreturn t", "chunk doesn't return a table constructor"},
+		{"unfinished", "return {\n  a = 1,\n", "3:1: unfinished table constructor"},
+		{"malformed key", "return { [1 = 2 }", "1:18: malformed table key"},
+		{"malformed number", "return { 1x }", "1:10: malformed number '1x'"},
+		{"lexer error", "return { 'a }", "1:14: unfinished string"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseReturnedTable(test.src)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestTopLevelReturn(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want int
+	}{
+		{"none", "local x = 1", -1},
+		{"chunk return", "local x = 1 return x", 4},
+		{"nested returns are skipped", "local function f() return 1 end return f", 8},
+		{"inside blocks", "if a then return 1 elseif b then return 2 else return 3 end", -1},
+		{"after loops", "while a do end repeat until b for i = 1, 2 do end return", 15},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokens, err := Tokenize(test.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := TopLevelReturn(tokens); got != test.want {
+				t.Errorf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseAssignedTable(t *testing.T) {
+	src := strings.Join([]string{
+		`package = "lua-cjson"`,
+		`local build = { type = "local" }`,
+		`description.build = { type = "field" }`,
+		`if false then build = { type = "nested" } end`,
+		`dependencies = { "lua >= 5.1" }`,
+		`build = {`,
+		`    type = "builtin",`,
+		`    modules = { cjson = { sources = { "lua_cjson.c" } } },`,
+		`}`,
+	}, "\n")
+
+	build, err := ParseAssignedTable(src, "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build == nil || build.String("type") != "builtin" || build.Line != 6 {
+		t.Fatalf("got %#v, want the top-level builtin build", build)
+	}
+
+	dependencies, err := ParseAssignedTable(src, "dependencies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dependencies.Strings(); len(got) != 1 || got[0] != "lua >= 5.1" {
+		t.Errorf("dependencies = %v", got)
+	}
+
+	missing, err := ParseAssignedTable(src, "source")
+	if missing != nil || err != nil {
+		t.Errorf("got %v, %v, want nil for a missing assignment", missing, err)
+	}
+}
@@ -15,21 +15,36 @@ var (
 	MainFileTemplate template.Template
 	LuaRcTemplate    template.Template
 	IgnoreTemplate   template.Template
+	// LuacheckTemplate is the block kopl manages in .luacheckrc
+	LuacheckTemplate template.Template
+	StyluaTemplate   template.Template
+	// VendorLoaderTemplate is the block kopl manages in main.lua
+	VendorLoaderTemplate template.Template
 )
 
 type TemplateArgsForInit struct {
 	ProjectName string
+	// VendorDir is where modules are required from by the vendor loader
+	VendorDir string
 }
 
 func init() {
 	MetaFileTemplate = parse("_meta.lua")
-	MainFileTemplate = parse("main.lua")
+	MainFileTemplate = parse("main.lua", "vendor_loader.lua")
 	LuaRcTemplate = parse(".luarc.json")
 	IgnoreTemplate = parse(".ignore")
+	LuacheckTemplate = parse(".luacheckrc")
+	StyluaTemplate = parse(".stylua.toml")
+	VendorLoaderTemplate = parse("vendor_loader.lua")
 }
 
-func parse(filename string) template.Template {
-	tmpl, err := template.ParseFS(templates, filename+".tmpl")
+// parse parses the template of filename, along with the ones it includes.
+func parse(filename string, includes ...string) template.Template {
+	patterns := []string{filename + ".tmpl"}
+	for _, include := range includes {
+		patterns = append(patterns, include+".tmpl")
+	}
+	tmpl, err := template.ParseFS(templates, patterns...)
 	if err != nil {
 		internalerror.ErrorExitf("While parsing _meta.lua.tmpl: %q", err)
 	}
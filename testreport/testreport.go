@@ -0,0 +1,180 @@
+// Package testreport contains the results of a plugin's tests and writes
+// them for people and CI.
+package testreport
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type Status string
+
+const (
+	Success Status = "success"
+	// Failure is a failed assertion
+	Failure Status = "failure"
+	// Error is anything else a test raised
+	Error   Status = "error"
+	Pending Status = "pending"
+)
+
+// Result is the outcome of a test.
+type Result struct {
+	// File is the spec the test is in, relative to the plugin
+	File string `json:"file"`
+	// Suite is the names of the describe blocks around the test
+	Suite   string  `json:"suite"`
+	Name    string  `json:"name"`
+	Status  Status  `json:"status"`
+	Message string  `json:"message,omitempty"`
+	Elapsed float64 `json:"elapsed"`
+}
+
+// FullName is the test's name with the names of its suite.
+func (r Result) FullName() string {
+	if r.Suite == "" {
+		return r.Name
+	}
+	return r.Suite + " " + r.Name
+}
+
+// Count returns how many results have the status.
+func Count(results []Result, status Status) int {
+	count := 0
+	for _, result := range results {
+		if result.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// Passed reports whether no test failed or raised an error.
+func Passed(results []Result) bool {
+	return Count(results, Failure) == 0 && Count(results, Error) == 0
+}
+
+// WriteText writes a line per test, the messages of the ones that didn't
+// pass and a summary like busted's.
+func WriteText(w io.Writer, results []Result) error {
+	var elapsed float64
+	for _, result := range results {
+		elapsed += result.Elapsed
+		_, err := fmt.Fprintf(w, "[%s] %s\n", strings.ToUpper(string(result.Status)), result.FullName())
+		if err != nil {
+			return err
+		}
+		if result.Message != "" {
+			_, err = fmt.Fprintf(w, "    %s\n", strings.ReplaceAll(result.Message, "\n", "\n    "))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(
+		w,
+		"%d successes / %d failures / %d errors / %d pending : %.3f seconds\n",
+		Count(results, Success),
+		Count(results, Failure),
+		Count(results, Error),
+		Count(results, Pending),
+		elapsed,
+	)
+	return err
+}
+
+// WriteJUnit writes a JUnit XML report with a test suite per spec file.
+func WriteJUnit(w io.Writer, results []Result) error {
+	report := junitTestSuites{}
+	suites := map[string]int{}
+	for _, result := range results {
+		i, ok := suites[result.File]
+		if !ok {
+			i = len(report.Suites)
+			suites[result.File] = i
+			report.Suites = append(report.Suites, junitTestSuite{Name: result.File})
+		}
+		suite := &report.Suites[i]
+
+		testCase := junitTestCase{
+			Name:      result.FullName(),
+			ClassName: result.File,
+			Time:      result.Elapsed,
+		}
+		switch result.Status {
+		case Failure:
+			testCase.Failure = &junitProblem{Message: firstLine(result.Message), Text: result.Message}
+			suite.Failures++
+		case Error:
+			testCase.Error = &junitProblem{Message: firstLine(result.Message), Text: result.Message}
+			suite.Errors++
+		case Pending:
+			testCase.Skipped = &struct{}{}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, testCase)
+		suite.Tests++
+		suite.Time += result.Elapsed
+	}
+	for _, suite := range report.Suites {
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		report.Skipped += suite.Skipped
+		report.Time += suite.Time
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(report)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     float64          `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     float64         `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// StateDir returns kopl's directory for persistent state,
+// $XDG_STATE_HOME/kopl or ~/.local/state/kopl.
+func StateDir() (string, error) {
+	return xdgDir("XDG_STATE_HOME", ".local/state")
+}
+
+// DataDir returns kopl's directory for data like downloaded tools,
+// $XDG_DATA_HOME/kopl or ~/.local/share/kopl.
+func DataDir() (string, error) {
+	return xdgDir("XDG_DATA_HOME", ".local/share")
+}
+
+func xdgDir(envVar string, fallback string) (string, error) {
+	base := os.Getenv(envVar)
+	// Relative paths are invalid per the spec and should be ignored
+	if !filepath.IsAbs(base) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, fallback)
+	}
+	return filepath.Join(base, "kopl"), nil
+}